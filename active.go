@@ -0,0 +1,123 @@
+package dirqueue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ActiveJob describes a job locked by a consumer
+type ActiveJob struct {
+	JobInfo
+	Owner     string    // hostname of the consumer holding the lock
+	PID       int       // process id of the consumer, if recorded
+	ClaimTime time.Time // when the lock was taken
+}
+
+// readLockFile parses the active lock file in path, which holds the
+// hostname and pid of its owner on separate lines
+func readLockFile(path string) (string, int, time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	lines := strings.Split(string(data), "\n")
+	owner := lines[0]
+	var pid int
+	if len(lines) > 1 {
+		pid, _ = strconv.Atoi(lines[1])
+	}
+	return owner, pid, stat.ModTime(), nil
+}
+
+// ActiveJobs returns the jobs currently locked by consumers, in pickup
+// order, with the owner of each lock
+func (dq *DirQueue) ActiveJobs() ([]ActiveJob, error) {
+	var jobs []ActiveJob
+	err := dq.Walk(func(info *JobInfo) error {
+		if info.State != StateActive {
+			return nil
+		}
+		owner, pid, claimed, err := readLockFile(filepath.Join(dq.ActiveDir, info.ID))
+		if os.IsNotExist(err) {
+			// Finished since the walk started
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, ActiveJob{JobInfo: *info, Owner: owner, PID: pid,
+			ClaimTime: claimed})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// claimJob locks the queued job qfname by linking a lock file into the
+// active directory, as IPC::DirQueue does on pickup. It returns false
+// if the job is already locked or no longer queued.
+func (dq *DirQueue) claimJob(qfname string) (bool, error) {
+	pathtmp := filepath.Join(dq.TmpDir, fmt.Sprintf("%s.%s.%d.%d",
+		qfname, dq.hostname, os.Getpid(), atomic.AddUint64(&tmpSeq, 1)))
+	lock := fmt.Sprintf("%s\n%d\n", dq.hostname, os.Getpid())
+	err := ioutil.WriteFile(pathtmp, []byte(lock), 0666)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(pathtmp)
+
+	pathactive := filepath.Join(dq.ActiveDir, qfname)
+	err = dq.fsop("link", pathactive, func() error {
+		return os.Link(pathtmp, pathactive)
+	})
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The job may have finished between listing and locking it
+	_, err = os.Stat(filepath.Join(dq.QueueDir, qfname))
+	if err != nil {
+		dq.unclaimJob(qfname)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// unclaimJob removes the lock on the queued job qfname, returning it
+// to the pending jobs
+func (dq *DirQueue) unclaimJob(qfname string) {
+	_ = os.Remove(filepath.Join(dq.ActiveDir, qfname))
+}
+
+// removeJob removes the data and control files of the claimed job
+// info, and then its lock, warning on failure
+func (dq *DirQueue) removeJob(info JobInfo) {
+	for _, path := range []string{
+		info.DataPath,
+		filepath.Join(dq.QueueDir, info.ID),
+		filepath.Join(dq.ActiveDir, info.ID),
+	} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to remove %q: %s\n", path, err.Error())
+		}
+	}
+}
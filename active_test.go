@@ -0,0 +1,90 @@
+package dirqueue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveJobs(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	for i := 0; i < 3; i++ {
+		err = dq.EnqueueString("active", &Options{Priority: uint8(10 + i)})
+		assert.Nil(t, err, "EnqueueString")
+	}
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+
+	active, err := dq.ActiveJobs()
+	assert.Nil(t, err, "ActiveJobs")
+	assert.Equal(t, 0, len(active), "none active")
+
+	before := time.Now().Add(-time.Second)
+	claimed, err := dq.claimJob(jobs[1].ID)
+	assert.Nil(t, err, "claimJob")
+	assert.True(t, claimed, "claimed")
+	// An IPC::DirQueue lock from another host
+	err = ioutil.WriteFile(filepath.Join(dq.ActiveDir, jobs[2].ID),
+		[]byte("perlhost\n4321\n"), 0644)
+	assert.Nil(t, err, "write lock")
+
+	active, err = dq.ActiveJobs()
+	assert.Nil(t, err, "ActiveJobs")
+	if !assert.Equal(t, 2, len(active), "two active") {
+		return
+	}
+	hostname, _ := os.Hostname()
+	assert.Equal(t, jobs[1].ID, active[0].ID, "first active id")
+	assert.Equal(t, hostname, active[0].Owner, "first owner")
+	assert.Equal(t, os.Getpid(), active[0].PID, "first pid")
+	assert.True(t, active[0].ClaimTime.After(before), "first claim time")
+	assert.Equal(t, StateActive, active[0].State, "first state")
+	assert.Equal(t, "perlhost", active[1].Owner, "second owner")
+	assert.Equal(t, 4321, active[1].PID, "second pid")
+}
+
+func TestClaimJob(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	err = dq.EnqueueString("claim", nil)
+	assert.Nil(t, err, "EnqueueString")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	id := jobs[0].ID
+
+	claimed, err := dq.claimJob(id)
+	assert.Nil(t, err, "claimJob")
+	assert.True(t, claimed, "claimed")
+	claimed, err = dq.claimJob(id)
+	assert.Nil(t, err, "claimJob again")
+	assert.False(t, claimed, "already claimed")
+	active, err := dq.ActiveCount()
+	assert.Nil(t, err, "ActiveCount")
+	assert.Equal(t, 1, active, "one active")
+
+	dq.unclaimJob(id)
+	claimed, err = dq.claimJob("00.nosuchjob")
+	assert.Nil(t, err, "claimJob missing")
+	assert.False(t, claimed, "missing job not claimed")
+	active, err = dq.ActiveCount()
+	assert.Nil(t, err, "ActiveCount")
+	assert.Equal(t, 0, active, "none active")
+
+	// Other errors checking the job is still queued release the lock
+	blocker := filepath.Join(dq.RootDir, "blocker")
+	assert.Nil(t, ioutil.WriteFile(blocker, nil, 0644), "write blocker")
+	queueDir := dq.QueueDir
+	dq.QueueDir = filepath.Join(blocker, "queue")
+	claimed, err = dq.claimJob(id)
+	dq.QueueDir = queueDir
+	assert.NotNil(t, err, "claimJob stat error")
+	assert.False(t, claimed, "not claimed on error")
+	active, err = dq.ActiveCount()
+	assert.Nil(t, err, "ActiveCount")
+	assert.Equal(t, 0, active, "lock released")
+}
@@ -0,0 +1,48 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownBand is returned when Options.Band names a priority band
+// the queue doesn't define
+var ErrUnknownBand = errors.New("unknown priority band")
+
+// PriorityBand maps a symbolic priority name (e.g. "urgent") to the
+// range of numeric priorities Min-Max, inclusive
+type PriorityBand struct {
+	Name string
+	Min  uint8
+	Max  uint8
+}
+
+// bands returns the queue's PriorityBands. They are read under lazyMu,
+// since createLazy loads them from the config file on first enqueue,
+// while other goroutines may be reading them.
+func (dq *DirQueue) bands() []PriorityBand {
+	dq.lazyMu.Lock()
+	defer dq.lazyMu.Unlock()
+	return dq.PriorityBands
+}
+
+// band returns the queue's PriorityBand called name
+func (dq *DirQueue) band(name string) (PriorityBand, error) {
+	for _, b := range dq.bands() {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return PriorityBand{}, fmt.Errorf("%w %q", ErrUnknownBand, name)
+}
+
+// BandName returns the name of the first of the queue's PriorityBands
+// containing priority, or "" if there is none
+func (dq *DirQueue) BandName(priority uint8) string {
+	for _, b := range dq.bands() {
+		if priority >= b.Min && priority <= b.Max {
+			return b.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package dirqueue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityBands(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	dq.PriorityBands = []PriorityBand{
+		{Name: "urgent", Min: 0, Max: 19},
+		{Name: "normal", Min: 20, Max: 79},
+		{Name: "bulk", Min: 80, Max: 99},
+	}
+
+	// Default priority 50 is within normal, so kept
+	err = dq.EnqueueString("normal", &Options{Band: "normal", Priority: 50})
+	assert.Nil(t, err, "EnqueueString normal")
+	// Priority outside the band is replaced by the band minimum, in a
+	// copy of the caller's options
+	urgent := &Options{Band: "urgent", Priority: 50}
+	err = dq.EnqueueString("urgent", urgent)
+	assert.Nil(t, err, "EnqueueString urgent")
+	assert.Equal(t, uint8(50), urgent.Priority, "caller options untouched")
+	err = dq.EnqueueString("bulk", &Options{Band: "bulk"})
+	assert.Nil(t, err, "EnqueueString bulk")
+	err = dq.EnqueueString("x", &Options{Band: "whenever"})
+	assert.True(t, errors.Is(err, ErrUnknownBand), "EnqueueString unknown band")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 3, len(jobs), "three jobs") {
+		return
+	}
+	assert.Equal(t, uint8(0), jobs[0].Priority, "urgent priority")
+	assert.Equal(t, "urgent", jobs[0].Band, "urgent band")
+	assert.Equal(t, uint8(50), jobs[1].Priority, "normal priority")
+	assert.Equal(t, "normal", jobs[1].Band, "normal band")
+	assert.Equal(t, uint8(80), jobs[2].Priority, "bulk priority")
+	assert.Equal(t, "bulk", jobs[2].Band, "bulk band")
+
+	stats, err := dq.Stats()
+	assert.Nil(t, err, "Stats")
+	assert.Equal(t, map[string]int{"urgent": 1, "normal": 1, "bulk": 1},
+		stats.Bands, "Stats.Bands")
+
+	assert.Equal(t, "", (&DirQueue{}).BandName(50), "no bands")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runActive(args []string) error {
+	fs := flag.NewFlagSet("active", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	jobs, err := dq.ActiveJobs()
+	if err != nil {
+		return err
+	}
+	printActiveJobs(os.Stdout, jobs)
+	return nil
+}
+
+func printActiveJobs(w io.Writer, jobs []dirqueue.ActiveJob) {
+	for _, job := range jobs {
+		held := time.Since(job.ClaimTime).Truncate(time.Second)
+		fmt.Fprintf(w, "%s  %s:%d  %s\n", job.ID, job.Owner, job.PID, held)
+	}
+}
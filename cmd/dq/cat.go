@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runCat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+	if fs.NArg() > 1 {
+		return errors.New("at most one JOBID may be given")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+
+	// An empty jobid peeks at the head of the queue
+	info, err := dq.Peek(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Reverse any transforms (e.g. gzip) applied on enqueue
+	fh, err := dq.OpenPayload(info)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	printJobInfo(os.Stderr, info)
+	_, err = io.Copy(os.Stdout, fh)
+	return err
+}
+
+func printJobInfo(w io.Writer, info *dirqueue.JobInfo) {
+	fmt.Fprintf(w, "id:       %s\n", info.ID)
+	if info.Band != "" {
+		fmt.Fprintf(w, "priority: %d (%s)\n", info.Priority, info.Band)
+	} else {
+		fmt.Fprintf(w, "priority: %d\n", info.Priority)
+	}
+	fmt.Fprintf(w, "enqueued: %s\n", info.EnqueueTime.Format("2006-01-02 15:04:05.000000"))
+	fmt.Fprintf(w, "size:     %d\n", info.Size)
+	fmt.Fprintf(w, "host:     %s\n", info.Hostname)
+
+	keys := make([]string, 0, len(info.Metadata))
+	for k := range info.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s: %s\n", k, info.Metadata[k])
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	olderThan := fs.Duration("older-than", time.Hour, "only remove files unmodified for `age`")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+	if *olderThan <= 0 {
+		return errors.New("--older-than must be positive")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	tmp, err := dq.RemoveStaleTmp(*olderThan)
+	if err != nil {
+		return err
+	}
+	data, err := dq.RemoveOrphanedData(*olderThan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d stale tmp files, %d orphaned data files\n", tmp, data)
+	return nil
+}
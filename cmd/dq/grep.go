@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+// metaFlag collects repeated --meta key=value flags
+type metaFlag map[string]string
+
+func (m metaFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metaFlag) Set(s string) error {
+	idx := strings.Index(s, "=")
+	if idx < 1 {
+		return fmt.Errorf("invalid key=value %q", s)
+	}
+	m[s[:idx]] = s[idx+1:]
+	return nil
+}
+
+func runGrep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "match jobs with metadatum `key=value` (repeatable)")
+	olderThan := fs.Duration("older-than", 0, "match jobs enqueued over `age` ago")
+	cancel := fs.Bool("cancel", false, "remove matching pending jobs")
+	reprioritize := fs.Int("reprioritize", -1, "requeue matching pending jobs at `priority`")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+	if *cancel && *reprioritize >= 0 {
+		return errors.New("--cancel and --reprioritize are mutually exclusive")
+	}
+	if *reprioritize > 99 {
+		return errors.New("--reprioritize must be between 0 and 99")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	query := dirqueue.Query{Meta: meta, MinAge: *olderThan}
+	if *cancel || *reprioritize >= 0 {
+		// Only pending jobs can be acted on
+		query.State = dirqueue.StatePending
+	}
+	jobs, err := dq.Search(query)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		info := &jobs[i]
+		switch {
+		case *cancel:
+			err = dq.CancelJob(info)
+		case *reprioritize >= 0:
+			// info is updated with the job's new ID
+			err = dq.Reprioritize(info, uint8(*reprioritize))
+		default:
+			printJobLine(os.Stdout, info)
+			continue
+		}
+		if errors.Is(err, dirqueue.ErrJobNotPending) {
+			fmt.Fprintf(os.Stderr, "%s: skipped, no longer pending\n", info.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", info.ID, err)
+		}
+		printJobLine(os.Stdout, info)
+	}
+	return nil
+}
+
+func printJobLine(w io.Writer, info *dirqueue.JobInfo) {
+	age := info.Age().Truncate(time.Second)
+	fmt.Fprintf(w, "%s  %s  %s\n", info.ID, info.State, age)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+// listEntry is the JSON form of a job listed by dq list
+type listEntry struct {
+	ID          string            `json:"id"`
+	State       string            `json:"state"`
+	Priority    uint8             `json:"priority"`
+	EnqueueTime time.Time         `json:"enqueue_time"`
+	Age         string            `json:"age"`
+	Size        int64             `json:"size"`
+	Hostname    string            `json:"hostname"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	active := fs.Bool("active", false, "list active jobs instead of pending ones")
+	asJSON := fs.Bool("json", false, "output jobs as a JSON array")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	query := dirqueue.Query{State: dirqueue.StatePending}
+	if *active {
+		query.State = dirqueue.StateActive
+	}
+	jobs, err := dq.Search(query)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJobsJSON(os.Stdout, jobs)
+	}
+	for i := range jobs {
+		printJobDetail(os.Stdout, &jobs[i])
+	}
+	return nil
+}
+
+func printJobDetail(w io.Writer, info *dirqueue.JobInfo) {
+	age := info.Age().Truncate(time.Second)
+	keys := make([]string, 0, len(info.Metadata))
+	for k := range info.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + info.Metadata[k]
+	}
+	meta := strings.Join(pairs, ",")
+	if meta == "" {
+		meta = "-"
+	}
+	fmt.Fprintf(w, "%s  %02d  %s  %d  %s  %s\n", info.ID, info.Priority, age,
+		info.Size, info.Hostname, meta)
+}
+
+func printJobsJSON(w io.Writer, jobs []dirqueue.JobInfo) error {
+	entries := make([]listEntry, len(jobs))
+	for i, info := range jobs {
+		entries[i] = listEntry{
+			ID:          info.ID,
+			State:       string(info.State),
+			Priority:    info.Priority,
+			EnqueueTime: info.EnqueueTime,
+			Age:         info.Age().Truncate(time.Second).String(),
+			Size:        info.Size,
+			Hostname:    info.Hostname,
+			Metadata:    info.Metadata,
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
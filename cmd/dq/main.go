@@ -0,0 +1,56 @@
+// dq is a command-line tool for inspecting and managing dirqueue queues
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"active":  {runActive, "active --queue DIR"},
+	"cat":     {runCat, "cat --queue DIR [JOBID]"},
+	"gc":      {runGC, "gc --queue DIR [--older-than AGE]"},
+	"grep":    {runGrep, "grep --queue DIR [--meta KEY=VALUE]... [--older-than AGE] [--cancel | --reprioritize N]"},
+	"list":    {runList, "list --queue DIR [--active] [--json]"},
+	"mv":      {runMv, "mv --from DIR --to DIR [--meta KEY=VALUE]... [--older-than AGE]"},
+	"purge":   {runPurge, "purge --queue DIR ([--priority N] [--meta KEY=VALUE]... [--older-than AGE] | --all)"},
+	"requeue": {runRequeue, "requeue --queue DIR [--from active] (--id JOBID | --all)"},
+	"stats":   {runStats, "stats --queue DIR [--watch INTERVAL]"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: dq <command> [options]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  dq %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dq: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dq %s: %s\n", name, err)
+		os.Exit(1)
+	}
+}
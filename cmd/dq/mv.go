@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runMv(args []string) error {
+	fs := flag.NewFlagSet("mv", flag.ExitOnError)
+	from := fs.String("from", "", "queue root directory to move jobs from")
+	to := fs.String("to", "", "queue root directory to move jobs to")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "move jobs with metadatum `key=value` (repeatable)")
+	olderThan := fs.Duration("older-than", 0, "move jobs enqueued over `age` ago")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		return errors.New("--from and --to are required")
+	}
+
+	src, err := dirqueue.Open(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := dirqueue.Open(*to)
+	if err != nil {
+		return err
+	}
+	jobs, err := src.Search(dirqueue.Query{
+		State:  dirqueue.StatePending,
+		Meta:   meta,
+		MinAge: *olderThan,
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		info := &jobs[i]
+		err = src.MoveJob(info, dst)
+		if errors.Is(err, dirqueue.ErrJobNotPending) {
+			fmt.Fprintf(os.Stderr, "%s: skipped, no longer pending\n", info.ID)
+			continue
+		}
+		if errors.Is(err, dirqueue.ErrMirrorFailed) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", info.ID, err)
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", info.ID, err)
+		}
+		fmt.Println(info.ID)
+	}
+	return nil
+}
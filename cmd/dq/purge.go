@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runPurge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	priority := fs.Int("priority", -1, "only remove jobs at `priority`")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "only remove jobs with metadatum `key=value` (repeatable)")
+	olderThan := fs.Duration("older-than", 0, "only remove jobs enqueued over `age` ago")
+	all := fs.Bool("all", false, "remove every pending job")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+	if *priority > 99 {
+		return errors.New("--priority must be between 0 and 99")
+	}
+	filtered := *priority >= 0 || len(meta) > 0 || *olderThan > 0
+	if filtered == *all {
+		return errors.New("give --all, or any of --priority, --meta and --older-than")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	// Only pending jobs can be removed; active ones belong to consumers
+	query := dirqueue.Query{State: dirqueue.StatePending, Meta: meta, MinAge: *olderThan}
+	if *priority >= 0 {
+		query.MinPriority = uint8(*priority)
+		query.MaxPriority = uint8(*priority)
+		query.HasMaxPriority = true
+	}
+	jobs, err := dq.Search(query)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		info := &jobs[i]
+		err = dq.CancelJob(info)
+		if errors.Is(err, dirqueue.ErrJobNotPending) {
+			fmt.Fprintf(os.Stderr, "%s: skipped, no longer pending\n", info.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", info.ID, err)
+		}
+		printJobLine(os.Stdout, info)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runRequeue(args []string) error {
+	fs := flag.NewFlagSet("requeue", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	from := fs.String("from", "active", "where to requeue jobs from (only active is supported)")
+	id := fs.String("id", "", "requeue the job with this `jobid`")
+	all := fs.Bool("all", false, "requeue all jobs")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+	if *from != "active" {
+		return fmt.Errorf("unsupported --from %q: queues have no dead-letter area", *from)
+	}
+	if (*id == "") == !*all {
+		return errors.New("exactly one of --id and --all is required")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+	jobs, err := dq.Search(dirqueue.Query{State: dirqueue.StateActive})
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range jobs {
+		info := &jobs[i]
+		if *id != "" && info.ID != *id {
+			continue
+		}
+		found = true
+		err = dq.RequeueJob(info)
+		if errors.Is(err, dirqueue.ErrJobNotActive) {
+			fmt.Fprintf(os.Stderr, "%s: skipped, no longer active\n", info.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", info.ID, err)
+		}
+		fmt.Println(info.ID)
+	}
+	if *id != "" && !found {
+		return fmt.Errorf("no active job %q", *id)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	queue := fs.String("queue", "", "queue root directory")
+	watch := fs.Duration("watch", 0, "redisplay stats every `interval`")
+	fs.Parse(args)
+	if *queue == "" {
+		return errors.New("--queue is required")
+	}
+
+	dq, err := dirqueue.Open(*queue)
+	if err != nil {
+		return err
+	}
+
+	for {
+		stats, err := dq.Stats()
+		if err != nil {
+			return err
+		}
+		if *watch > 0 {
+			fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+		}
+		printStats(os.Stdout, stats)
+		if *watch <= 0 {
+			return nil
+		}
+		time.Sleep(*watch)
+	}
+}
+
+func printStats(w io.Writer, stats *dirqueue.Stats) {
+	fmt.Fprintf(w, "pending:  %d jobs, %d bytes\n", stats.Pending, stats.PendingBytes)
+	fmt.Fprintf(w, "active:   %d jobs, %d bytes\n", stats.Active, stats.ActiveBytes)
+	if stats.Quarantined > 0 {
+		fmt.Fprintf(w, "badctrl:  %d malformed control files\n", stats.Quarantined)
+	}
+	if stats.OldestPending.IsZero() {
+		fmt.Fprintf(w, "oldest:   -\n")
+	} else {
+		age := time.Since(stats.OldestPending).Truncate(time.Second)
+		fmt.Fprintf(w, "oldest:   %s\n", age)
+	}
+
+	priorities := make([]int, 0, len(stats.Priorities))
+	for pri := range stats.Priorities {
+		priorities = append(priorities, int(pri))
+	}
+	// Lower numbers are picked up first, so list them first
+	sort.Ints(priorities)
+	for _, pri := range priorities {
+		fmt.Fprintf(w, "priority %02d: %d\n", pri, stats.Priorities[uint8(pri)])
+	}
+
+	bands := make([]string, 0, len(stats.Bands))
+	for band := range stats.Bands {
+		bands = append(bands, band)
+	}
+	sort.Strings(bands)
+	for _, band := range bands {
+		fmt.Fprintf(w, "band %s: %d\n", band, stats.Bands[band])
+	}
+}
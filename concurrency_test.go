@@ -0,0 +1,94 @@
+package dirqueue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConcurrentUse shares one DirQueue between goroutines enqueueing
+// and goroutines claiming and removing jobs. Run with -race.
+func TestConcurrentUse(t *testing.T) {
+	const producers, consumers, perProducer = 8, 4, 50
+
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+
+	var wg sync.WaitGroup
+	var producing int32 = producers
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			defer atomic.AddInt32(&producing, -1)
+			for i := 0; i < perProducer; i++ {
+				err := dq.EnqueueString(fmt.Sprintf("%d-%d", p, i),
+					&Options{Priority: uint8(i % 3)})
+				if err != nil {
+					t.Errorf("EnqueueString: %s", err)
+				}
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				done := atomic.LoadInt32(&producing) == 0
+				jobs, err := dq.ListJobs(func(info *JobInfo) bool {
+					return info.State == StatePending
+				})
+				if err != nil {
+					t.Errorf("ListJobs: %s", err)
+					return
+				}
+				if done && len(jobs) == 0 {
+					return
+				}
+				for _, info := range jobs {
+					claimed, err := dq.claimJob(info.ID)
+					if err != nil {
+						t.Errorf("claimJob: %s", err)
+						return
+					}
+					if !claimed {
+						continue
+					}
+					data, err := ioutil.ReadFile(info.DataPath)
+					if err != nil {
+						t.Errorf("ReadFile: %s", err)
+					}
+					dq.removeJob(info)
+					mu.Lock()
+					seen[string(data)]++
+					mu.Unlock()
+				}
+				_, err = dq.Stats()
+				if err != nil {
+					t.Errorf("Stats: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, producers*perProducer, len(seen), "every job consumed")
+	for payload, n := range seen {
+		if n != 1 {
+			t.Errorf("payload %q consumed %d times", payload, n)
+		}
+	}
+	for _, dir := range []string{dq.TmpDir, dq.QueueDir, dq.ActiveDir} {
+		names, err := queueEntries(dir)
+		assert.Nil(t, err, "queueEntries")
+		assert.Equal(t, 0, len(names), dir+" empty")
+	}
+}
@@ -0,0 +1,168 @@
+package dirqueue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configFile is the name of the queue config file in the queue root
+const configFile = "dirqueue.conf"
+
+// formatVersion is the queue layout version this package writes
+const formatVersion = 1
+
+// dataFanout is the number of hashed directory levels under DataDir
+const dataFanout = 2
+
+// ErrIncompatibleQueue is returned when a queue's config file records
+// a layout this package can't use
+var ErrIncompatibleQueue = errors.New("incompatible queue")
+
+// config is the persisted queue config. It is written in the same
+// "key: value" line format as control files, e.g.
+//
+//	version: 1
+//	fanout: 2
+//	band: urgent 0-19
+type config struct {
+	Version       int
+	Fanout        int
+	PriorityBands []PriorityBand
+}
+
+// readConfig parses the config file at path
+func readConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		idx := strings.Index(line, ": ")
+		if idx < 1 {
+			return nil, fmt.Errorf("%q: invalid line %q", path, line)
+		}
+		key, val := line[:idx], line[idx+2:]
+		switch key {
+		case "version":
+			cfg.Version, err = strconv.Atoi(val)
+		case "fanout":
+			cfg.Fanout, err = strconv.Atoi(val)
+		case "band":
+			var band PriorityBand
+			_, err = fmt.Sscanf(val, "%s %d-%d", &band.Name, &band.Min, &band.Max)
+			cfg.PriorityBands = append(cfg.PriorityBands, band)
+		}
+		// Unknown keys are ignored, for forward compatibility
+		if err != nil {
+			return nil, fmt.Errorf("%q: invalid %s %q", path, key, val)
+		}
+	}
+	return cfg, nil
+}
+
+// check returns ErrIncompatibleQueue if cfg records a layout this
+// package can't use
+func (cfg *config) check(path string) error {
+	if cfg.Version < 1 || cfg.Version > formatVersion {
+		return fmt.Errorf("%w: %q has format version %d (supported: %d)",
+			ErrIncompatibleQueue, path, cfg.Version, formatVersion)
+	}
+	if cfg.Fanout != dataFanout {
+		return fmt.Errorf("%w: %q has data fanout %d (supported: %d)",
+			ErrIncompatibleQueue, path, cfg.Fanout, dataFanout)
+	}
+	return nil
+}
+
+// writeConfig writes cfg to the config file in rootdir. If overwrite
+// is false and the file already exists, os.ErrExist is returned.
+func writeConfig(rootdir string, cfg *config, overwrite bool) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "version: %d\n", cfg.Version)
+	fmt.Fprintf(&buf, "fanout: %d\n", cfg.Fanout)
+	for _, b := range cfg.PriorityBands {
+		fmt.Fprintf(&buf, "band: %s %d-%d\n", b.Name, b.Min, b.Max)
+	}
+
+	// Write to a tmp file and link or rename it into place, so readers
+	// never see a partial config
+	fh, err := ioutil.TempFile(rootdir, "."+configFile)
+	if err != nil {
+		return err
+	}
+	pathtmp := fh.Name()
+	defer os.Remove(pathtmp)
+	_, err = fh.Write(buf.Bytes())
+	if err != nil {
+		_ = fh.Close()
+		return err
+	}
+	err = fh.Close()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(rootdir, configFile)
+	if overwrite {
+		return os.Rename(pathtmp, path)
+	}
+	return os.Link(pathtmp, path)
+}
+
+// loadConfig reads and checks the config file in dq.RootDir, and
+// applies any priority bands it defines. If the file doesn't exist it
+// is created if create is set, and otherwise ignored (e.g. for queues
+// created by IPC::DirQueue).
+func (dq *DirQueue) loadConfig(create bool) error {
+	path := filepath.Join(dq.RootDir, configFile)
+	cfg, err := readConfig(path)
+	if os.IsNotExist(err) && !create {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		err = writeConfig(dq.RootDir,
+			&config{Version: formatVersion, Fanout: dataFanout}, false)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+		// Re-read, in case another process created it first
+		cfg, err = readConfig(path)
+	}
+	if err != nil {
+		return err
+	}
+	err = cfg.check(path)
+	if err != nil {
+		return err
+	}
+	if len(cfg.PriorityBands) > 0 {
+		dq.PriorityBands = cfg.PriorityBands
+	}
+	return nil
+}
+
+// SaveConfig persists the queue's PriorityBands to its config file, so
+// that every client opening the queue shares them
+func (dq *DirQueue) SaveConfig() error {
+	bands := dq.bands()
+	for _, b := range bands {
+		if !reSimpleName.MatchString(b.Name) {
+			return fmt.Errorf("invalid priority band name %q", b.Name)
+		}
+	}
+	cfg := &config{
+		Version:       formatVersion,
+		Fanout:        dataFanout,
+		PriorityBands: bands,
+	}
+	return writeConfig(dq.RootDir, cfg, true)
+}
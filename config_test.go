@@ -0,0 +1,149 @@
+package dirqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	path := filepath.Join(testq, configFile)
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "config file created")
+	assert.Equal(t, "version: 1\nfanout: 2\n", string(data), "config contents")
+
+	// Priority bands are persisted and loaded
+	dq.PriorityBands = []PriorityBand{
+		{Name: "urgent", Min: 0, Max: 19},
+		{Name: "bulk", Min: 80, Max: 99},
+	}
+	err = dq.SaveConfig()
+	assert.Nil(t, err, "SaveConfig")
+	dq2, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	assert.Equal(t, dq.PriorityBands, dq2.PriorityBands, "bands loaded")
+
+	dq.PriorityBands = []PriorityBand{{Name: "bad name", Min: 0, Max: 9}}
+	err = dq.SaveConfig()
+	assert.NotNil(t, err, "SaveConfig invalid band name")
+
+	// Unknown keys are ignored, unsupported layouts rejected
+	err = ioutil.WriteFile(path, []byte("version: 1\nfanout: 2\nfuture: yes\n"), 0644)
+	assert.Nil(t, err, "write config")
+	_, err = New(testq)
+	assert.Nil(t, err, "unknown key ignored")
+
+	err = ioutil.WriteFile(path, []byte("version: 2\nfanout: 2\n"), 0644)
+	assert.Nil(t, err, "write config")
+	_, err = New(testq)
+	assert.True(t, errors.Is(err, ErrIncompatibleQueue), "newer version rejected")
+
+	err = ioutil.WriteFile(path, []byte("version: 1\nfanout: 3\n"), 0644)
+	assert.Nil(t, err, "write config")
+	_, err = New(testq)
+	assert.True(t, errors.Is(err, ErrIncompatibleQueue), "fanout rejected")
+
+	err = ioutil.WriteFile(path, []byte("version one\n"), 0644)
+	assert.Nil(t, err, "write config")
+	_, err = New(testq)
+	assert.NotNil(t, err, "malformed config rejected")
+
+	nukeQueue(t, testq)
+}
+
+func TestCreateOpen(t *testing.T) {
+	testq := filepath.Join(t.TempDir(), "q")
+
+	_, err := Open(testq)
+	assert.True(t, errors.Is(err, ErrQueueNotFound), "Open missing queue")
+	_, err = os.Stat(testq)
+	assert.True(t, os.IsNotExist(err), "Open creates nothing")
+
+	bands := []PriorityBand{{Name: "urgent", Min: 0, Max: 9}}
+	dq, err := Create(testq, &CreateOptions{PriorityBands: bands})
+	assert.Nil(t, err, "Create")
+	err = dq.EnqueueString("created", nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	_, err = Create(testq, nil)
+	assert.True(t, errors.Is(err, ErrQueueExists), "Create existing queue")
+
+	dq, err = Open(testq)
+	assert.Nil(t, err, "Open")
+	assert.Equal(t, bands, dq.PriorityBands, "bands loaded")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 1, count, "job visible")
+
+	// Queues without a config file (e.g. from IPC::DirQueue) open fine
+	err = os.Remove(filepath.Join(testq, configFile))
+	assert.Nil(t, err, "remove config")
+	_, err = Open(testq)
+	assert.Nil(t, err, "Open without config")
+	_, err = os.Stat(filepath.Join(testq, configFile))
+	assert.True(t, os.IsNotExist(err), "Open doesn't create config")
+	// Nor does New, since its root may not be writable
+	_, err = New(testq)
+	assert.Nil(t, err, "New without config")
+	_, err = os.Stat(filepath.Join(testq, configFile))
+	assert.True(t, os.IsNotExist(err), "New doesn't create config")
+
+	// A missing subdirectory fails
+	err = os.Remove(filepath.Join(testq, "active"))
+	assert.Nil(t, err, "remove active")
+	_, err = Open(testq)
+	assert.True(t, errors.Is(err, ErrQueueNotFound), "Open incomplete queue")
+}
+
+func TestNewLazy(t *testing.T) {
+	testq := filepath.Join(t.TempDir(), "q")
+
+	dq, err := NewLazy(testq)
+	assert.Nil(t, err, "NewLazy")
+	_, err = os.Stat(testq)
+	assert.True(t, os.IsNotExist(err), "NewLazy creates nothing")
+
+	err = dq.EnqueueString("lazy", nil)
+	assert.Nil(t, err, "EnqueueString")
+	for _, subdir := range []string{"tmp", "data", "queue", "active", configFile} {
+		_, err = os.Stat(filepath.Join(testq, subdir))
+		assert.Nil(t, err, "created "+subdir)
+	}
+	err = dq.EnqueueString("lazy", nil)
+	assert.Nil(t, err, "EnqueueString again")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 2, count, "two jobs")
+}
+
+func TestNewLazyBandsConcurrent(t *testing.T) {
+	testq := filepath.Join(t.TempDir(), "q")
+	bands := []PriorityBand{{Name: "urgent", Min: 0, Max: 9}}
+	_, err := Create(testq, &CreateOptions{PriorityBands: bands})
+	assert.Nil(t, err, "Create")
+
+	// The first enqueue loads the bands while others read them
+	dq, err := NewLazy(testq)
+	assert.Nil(t, err, "NewLazy")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dq.BandName(5)
+		}
+	}()
+	err = dq.EnqueueString("lazy", &Options{Band: "urgent"})
+	assert.Nil(t, err, "EnqueueString")
+	<-done
+	assert.Equal(t, "urgent", dq.BandName(5), "bands loaded")
+}
@@ -1,43 +1,235 @@
 package dirqueue
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/tejainece/uu"
 )
 
+// DirQueue is an IPC::DirQueue-compatible queue rooted at RootDir.
+// A DirQueue may be shared by multiple goroutines, provided its
+// exported fields aren't changed once it is in use.
 type DirQueue struct {
 	RootDir   string
 	TmpDir    string
 	DataDir   string
 	QueueDir  string
 	ActiveDir string
+
+	// Statsd, if set, is sent enqueue, pickup and finish counters, and
+	// enqueue timings
+	Statsd *StatsdEmitter
+
+	// MaxJobs and MaxBytes, if non-zero, limit the number of jobs and
+	// total payload bytes the queue will accept
+	MaxJobs  int
+	MaxBytes int64
+
+	// Tenant, if set (via Tenant.Add), applies the tenant's aggregate
+	// limits across all of its queues
+	Tenant *Tenant
+
+	// Mirror, if set, is sent a copy of every job enqueued. By default
+	// the copy is made synchronously, and a failure is returned as
+	// ErrMirrorFailed (the job itself having been enqueued). If
+	// MirrorAsync is set the copy is made in the background by a single
+	// worker, and failures are only warned about. Enqueues block while
+	// the worker is mirrorBacklog copies behind, and Close waits for
+	// outstanding copies.
+	Mirror      *DirQueue
+	MirrorAsync bool
+
+	// Shadow, if set, is sent a copy of ShadowPercent percent of the
+	// jobs enqueued, chosen at random, e.g. for a new consumer under
+	// test. Every job records whether it was sampled in its
+	// "shadow_sampled" metadatum ("true" or "false"). Shadow failures
+	// are only warned about.
+	Shadow        *DirQueue
+	ShadowPercent float64
+
+	// Transforms, if set, are applied in order to the payload of each
+	// job enqueued (e.g. to compress it), and recorded in the job's
+	// "transforms" metadatum so OpenPayload can reverse them. Enqueues
+	// setting that metadatum themselves are rejected.
+	Transforms []Transform
+
+	// LargeDataDir, if set, holds the data files of payloads over
+	// LargeThreshold bytes (as stored, after any Transforms), keeping
+	// the DataDir tree small. It may be on another filesystem, in which
+	// case large payloads are copied there.
+	LargeDataDir   string
+	LargeThreshold int64
+
+	// Journal, if set, appends an event for every job enqueued, picked
+	// up, finished or returned to the queue's journal file, for reading
+	// with a JournalReader. The journal isn't rotated, so it grows until
+	// removed.
+	Journal bool
+
+	// OpTimeout, if set, limits how long enqueues and queue scans wait
+	// on each create, link, mkdir or readdir call, e.g. for queues on
+	// NFS or FUSE mounts that can hang. Timeouts are returned as
+	// FSTimeoutErrors.
+	OpTimeout time.Duration
+
+	// FSRetries, if set, is how many times create, mkdir and readdir
+	// calls are retried after transient network filesystem errors
+	// (ESTALE, EIO), waiting FSRetryBackoff (default 10ms), doubling
+	// each time, between attempts. Links are never retried, since a
+	// link can succeed but still report an error.
+	FSRetries      int
+	FSRetryBackoff time.Duration
+
+	// Defaults, if set, are the Options used for enqueues without any,
+	// and their Metadata is added to that of enqueues with Options
+	// (which take precedence), e.g. for static service or environment
+	// tags
+	Defaults *Options
+
+	// Schema, if set, is checked against the metadata of every job
+	// enqueued (after Defaults are applied), and nonconforming jobs
+	// rejected with a SchemaError
+	Schema *Schema
+
+	// PriorityBands, if set, map symbolic priority names to ranges of
+	// numeric priorities, for use in Options.Band
+	PriorityBands []PriorityBand
+
+	// Clock, if set, is used instead of time.Now for job enqueue times
+	// (and so their IDs and pickup order) and journal events, e.g. for
+	// deterministic tests (see dirqueuetest.NewClock)
+	Clock func() time.Time
+
+	// FSFault, if set, is called with the operation and path before
+	// each create, link, mkdir or readdir call, and a non-nil error it
+	// returns is returned in place of the call's, e.g. for fault
+	// injection tests (see dirqueuetest.InjectFault)
+	FSFault func(op, path string) error
+
+	// Cached at construction, since they're the same for every job
+	hostname string
+	qfhash   string
+
+	// Set by NewLazy until the queue directories have been created
+	lazy   bool
+	lazyMu sync.Mutex
+
+	// Background mirror worker state, for MirrorAsync
+	mirrorMu sync.Mutex
+	mirrorQ  *mirrorQueue
 }
 
 type Options struct {
 	Metadata map[string]string
 	Priority uint8
+
+	// MaxWriteBytesPerSec, if set, limits the rate at which the
+	// payload is written to the queue, so that enqueueing a huge
+	// payload onto a shared spool disk doesn't starve consumers of I/O
+	MaxWriteBytesPerSec int64
+
+	// Band, if set, names one of the queue's PriorityBands. The job is
+	// given the band's Min priority, unless Priority is already within
+	// the band.
+	Band string
+
+	// EnqueueTime, if set, is recorded as the job's enqueue time (and
+	// orders it within its priority) instead of the current time, e.g.
+	// to keep a requeued job's place and age
+	EnqueueTime time.Time
+
+	// Validate, if set, is called with the job metadata and payload size
+	// before anything is written to the queue, and any error it returns
+	// is returned from the enqueue. size is -1 if it can't be determined
+	// up front (e.g. for a pipe or network reader).
+	Validate func(meta map[string]string, size int64) error
+
+	// pretransformed, if set, records the transforms already applied
+	// to a payload copied from another job, which is stored as it is
+	pretransformed string
 }
 
 type Job struct {
 	ts          time.Time
 	opts        *Options
 	hostname    string
+	qfhash      string
 	size        int64
 	pathtmpdata string
 	pathdata    string
 	pathtmpctrl string
+	qcname      string
+	pathctrl    string
 }
 
+// ErrMirrorFailed is returned when a job was enqueued, but copying
+// it to the mirror queue failed
+var ErrMirrorFailed = errors.New("job enqueued, but mirror enqueue failed")
+
+// tmpSeq numbers the tmp files staged by this process
+var tmpSeq uint64
+
+// Metadata keys recorded by the queue itself
+const (
+	// shadowSampledKey records whether a job was sampled for the
+	// shadow queue
+	shadowSampledKey = "shadow_sampled"
+	// transformsKey records the transforms applied to a job's payload,
+	// in order
+	transformsKey = "transforms"
+	// failoverOriginKey records the queue a FailoverQueue job was
+	// diverted from
+	failoverOriginKey = "failover_origin"
+	// scheduleKey and scheduledAtKey record the Schedule and tick a
+	// Scheduler job was enqueued for
+	scheduleKey    = "schedule"
+	scheduledAtKey = "scheduled_at"
+)
+
+// libraryKeys are the metadata keys written by the library itself,
+// including the exported ContentTypeKey, TraceparentKey and
+// TracestateKey, which Schemas always allow
+var libraryKeys = map[string]bool{
+	shadowSampledKey:  true,
+	transformsKey:     true,
+	failoverOriginKey: true,
+	scheduleKey:       true,
+	scheduledAtKey:    true,
+	ContentTypeKey:    true,
+	TraceparentKey:    true,
+	TracestateKey:     true,
+}
+
+// ErrQueueNotFound is returned by Open when rootdir isn't an existing
+// queue
+var ErrQueueNotFound = errors.New("queue not found")
+
+// ErrQueueExists is returned by Create when rootdir already holds a
+// queue
+var ErrQueueExists = errors.New("queue already exists")
+
+// Pools of per-enqueue staging buffers
+var copyBufPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 32*1024)
+	return &buf
+}}
+var ctrlBufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+var qfnameBufPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, 64)
+	return &buf
+}}
+
 // Regexen
-var reDot = regexp.MustCompile(`\.`)
 var reAlphanum = regexp.MustCompile(`[^A-Za-z0-9+_]`)
 var reControlKeyFormat = regexp.MustCompile(`^Q...$`)
 var reControlKeyBadChars = regexp.MustCompile("[:\000\n]")
@@ -54,6 +246,21 @@ func ensureDirExists(dir string) error {
 	return os.MkdirAll(dir, 0777)
 }
 
+// uuencodeLine returns data (at most 45 bytes) uuencoded as a single
+// line, with zero values encoded as spaces
+func uuencodeLine(data []byte) []byte {
+	enc := func(b byte) byte { return ' ' + b&0x3f }
+	line := make([]byte, 0, 2+(len(data)+2)/3*4)
+	line = append(line, enc(byte(len(data))))
+	for i := 0; i < len(data); i += 3 {
+		var b [3]byte
+		copy(b[:], data[i:])
+		line = append(line,
+			enc(b[0]>>2), enc(b[0]<<4|b[1]>>4), enc(b[1]<<2|b[2]>>6), enc(b[2]))
+	}
+	return append(line, '\n')
+}
+
 func hashStringToFilename(s string) string {
 	// # get a 16-bit checksum of the input, then uuencode that string
 	// $str = pack ("u*", unpack ("%16C*", $str));
@@ -61,7 +268,7 @@ func hashStringToFilename(s string) string {
 	for i := 0; i < len(s); i++ {
 		sum += int(s[i])
 	}
-	ustr := string(uu.EncodeLine([]byte(fmt.Sprintf("%d", sum))))
+	ustr := string(uuencodeLine(strconv.AppendInt(nil, int64(sum), 10)))
 
 	// # transcode from uuencode-space into safe, base64-ish space
 	// $str =~ y/ -_/A-Za-z0-9+_/;
@@ -83,28 +290,88 @@ func hashStringToFilename(s string) string {
 	return reAlphanum.ReplaceAllString(tstr, "")
 }
 
-func newJob(opts *Options) (Job, error) {
+// readerSize returns the number of bytes remaining in rdr, if it can
+// be determined without reading, or -1
+func readerSize(rdr io.Reader) int64 {
+	switch r := rdr.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		stat, err := r.Stat()
+		if err != nil || !stat.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return stat.Size() - offset
+	}
+	return -1
+}
+
+// hostnameHash returns the local hostname, and the queue filename
+// hash derived from it and our pid
+func hostnameHash() (string, string, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
-		return Job{}, err
+		return "", "", err
 	}
-	return Job{ts: time.Now().UTC(), opts: opts, hostname: hostname}, nil
+	return hostname, hashStringToFilename(fmt.Sprintf("%s%d", hostname, os.Getpid())), nil
+}
+
+// now returns the current time from dq.Clock, if set, or time.Now
+func (dq *DirQueue) now() time.Time {
+	if dq.Clock != nil {
+		return dq.Clock()
+	}
+	return time.Now()
+}
+
+func (dq *DirQueue) newJob(opts *Options) (Job, error) {
+	hostname, qfhash := dq.hostname, dq.qfhash
+	if qfhash == "" {
+		// Not constructed via New
+		var err error
+		hostname, qfhash, err = hostnameHash()
+		if err != nil {
+			return Job{}, err
+		}
+	}
+	ts := dq.now().UTC()
+	if !opts.EnqueueTime.IsZero() {
+		ts = opts.EnqueueTime.UTC()
+	}
+	return Job{ts: ts, opts: opts, hostname: hostname, qfhash: qfhash}, nil
 }
 
 func (j Job) newQueueFilename(appendRandom bool) string {
-	timestr := j.ts.Format("20060102030405.000000")
-	qfname := fmt.Sprintf("%02d.%20s.%s",
-		j.opts.Priority,
-		reDot.ReplaceAllString(timestr, ""),
-		hashStringToFilename(fmt.Sprintf("%s%d", j.hostname, os.Getpid())),
-	)
+	bufp := qfnameBufPool.Get().(*[]byte)
+	defer qfnameBufPool.Put(bufp)
+
+	// Equivalent to "%02d.%s.%s" with the priority, the timestamp
+	// formatted as "20060102150405.000000" with the dot removed, and
+	// the hostname hash
+	buf := (*bufp)[:0]
+	if j.opts.Priority < 10 {
+		buf = append(buf, '0')
+	}
+	buf = strconv.AppendUint(buf, uint64(j.opts.Priority), 10)
+	buf = append(buf, '.')
+	buf = j.ts.AppendFormat(buf, "20060102150405")
+	dot := len(buf)
+	buf = j.ts.AppendFormat(buf, ".000000")
+	buf = append(buf[:dot], buf[dot+1:]...)
+	buf = append(buf, '.')
+	buf = append(buf, j.qfhash...)
+	*bufp = buf
+	qfname := string(buf)
+
 	// This isn't normally needed, but if there's a collision
 	// subsequent retries will set appendRandom to true to try
 	// and avoid further collisions
 	if appendRandom {
-		s := rand.NewSource(time.Now().UnixNano())
-		r := rand.New(s)
-		qfname += fmt.Sprintf(".%d.%d", os.Getpid(), r.Intn(65536))
+		qfname += fmt.Sprintf(".%d.%d", os.Getpid(), rand.Intn(65536))
 	}
 	return qfname
 }
@@ -120,6 +387,9 @@ func (j Job) cleanup() {
 	if j.pathtmpctrl != "" {
 		_ = os.Remove(j.pathtmpctrl)
 	}
+	if j.pathctrl != "" {
+		_ = os.Remove(j.pathctrl)
+	}
 }
 
 func dqSubdir(rootdir string, subdir string) (string, error) {
@@ -149,12 +419,14 @@ func hashqfname(qfname string) (string, string, string) {
 
 // createHashedDataDir strips the last two characters from qfname
 // to create data hash directories in datadir
-func createHashedDataDir(datadir, qfname string) (string, string, error) {
+func (dq *DirQueue) createHashedDataDir(datadir, qfname string) (string, string, error) {
 	lvl1, lvl2, qfname := hashqfname(qfname)
 
 	// Create hashed data dir
 	pathdatadir := filepath.Join(datadir, lvl1, lvl2)
-	err := ensureDirExists(pathdatadir)
+	err := dq.fsop("mkdir", pathdatadir, func() error {
+		return ensureDirExists(pathdatadir)
+	})
 	if err != nil {
 		return "", "", err
 	}
@@ -166,17 +438,22 @@ func createHashedDataDir(datadir, qfname string) (string, string, error) {
 // filename qfname. On failure, it retries up to 10 times, with
 // modified filenames with additional random characters appended.
 // Returns the full path to the linked file on success.
-func linkIntoDir(pathsrc, dstdir, qfname string, job Job) (string, error) {
+func (dq *DirQueue) linkIntoDir(pathsrc, dstdir, qfname string, job Job) (string, error) {
 	var path string
 	maxRetries := 10
 
 	for retry := 1; retry <= maxRetries; retry++ {
 		path = filepath.Join(dstdir, qfname)
 
-		err := os.Link(pathsrc, path)
+		err := dq.fsop("link", path, func() error {
+			return os.Link(pathsrc, path)
+		})
 		if err == nil {
 			break
 		}
+		if errors.Is(err, ErrFSTimeout) {
+			return "", err
+		}
 
 		// Failed - check if we have hit maxRetries
 		if retry == maxRetries {
@@ -200,11 +477,6 @@ func linkIntoDir(pathsrc, dstdir, qfname string, job Job) (string, error) {
 }
 
 func createControlFile(pathtmpctrl string, job Job) error {
-	fh, err := os.Create(pathtmpctrl)
-	if err != nil {
-		return err
-	}
-
 	pathdata, err := filepath.Abs(job.pathdata)
 	if err != nil {
 		return err
@@ -212,27 +484,43 @@ func createControlFile(pathtmpctrl string, job Job) error {
 	tsSeconds := job.ts.Unix()
 	tsMicroseconds := int64(job.ts.UnixNano()/1000) - tsSeconds*1000000
 
-	fmt.Fprintf(fh, "QDFN: %s\n", pathdata)
-	fmt.Fprintf(fh, "QDSB: %d\n", job.size)
-	fmt.Fprintf(fh, "QSTT: %d\n", tsSeconds)
-	fmt.Fprintf(fh, "QSTM: %d\n", tsMicroseconds)
-	fmt.Fprintf(fh, "QSHN: %s\n", job.hostname)
+	// Build the control data in a pooled buffer, so it can be written
+	// with a single write
+	buf := ctrlBufPool.Get().(*bytes.Buffer)
+	defer ctrlBufPool.Put(buf)
+	buf.Reset()
+
+	fmt.Fprintf(buf, "QDFN: %s\n", pathdata)
+	fmt.Fprintf(buf, "QDSB: %d\n", job.size)
+	fmt.Fprintf(buf, "QSTT: %d\n", tsSeconds)
+	fmt.Fprintf(buf, "QSTM: %d\n", tsMicroseconds)
+	fmt.Fprintf(buf, "QSHN: %s\n", job.hostname)
 
 	// Check and store metadata
 	for k, v := range job.opts.Metadata {
 		// Check keys and values
-		if reControlKeyFormat.MatchString(k) ||
+		if k == "" || reControlKeyFormat.MatchString(k) ||
 			reControlKeyBadChars.MatchString(k) ||
 			reControlValBadChars.MatchString(v) {
-			_ = fh.Close()
-			job.cleanup()
 			return fmt.Errorf("invalid metadatum: %q", k)
 		}
-		fmt.Fprintf(fh, "%s: %s\n", k, v)
+		fmt.Fprintf(buf, "%s: %s\n", k, v)
+	}
+
+	fh, err := os.Create(pathtmpctrl)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(buf.Bytes())
+	if err != nil {
+		_ = fh.Close()
+		_ = os.Remove(pathtmpctrl)
+		return err
 	}
 
 	err = fh.Close()
 	if err != nil {
+		_ = os.Remove(pathtmpctrl)
 		return err
 	}
 
@@ -240,8 +528,12 @@ func createControlFile(pathtmpctrl string, job Job) error {
 }
 
 // New returns a reference to a DirQueue struct for the
-// queue in rootdir
+// queue in rootdir, creating it if necessary (see also Create and
+// Open). A config file is written for queues New creates, and
+// ErrIncompatibleQueue returned if an existing one records an
+// unsupported layout.
 func New(rootdir string) (*DirQueue, error) {
+	created := !queueExists(rootdir)
 	err := ensureDirExists(rootdir)
 	if err != nil {
 		return nil, err
@@ -266,15 +558,145 @@ func New(rootdir string) (*DirQueue, error) {
 		return nil, err
 	}
 
-	return &DirQueue{
+	hostname, qfhash, err := hostnameHash()
+	if err != nil {
+		return nil, err
+	}
+
+	dq := &DirQueue{
 		RootDir:   rootdir,
 		TmpDir:    pathtmpdir,
 		DataDir:   pathdatadir,
 		QueueDir:  pathqueuedir,
 		ActiveDir: pathactivedir,
+		hostname:  hostname,
+		qfhash:    qfhash,
+	}
+	err = dq.loadConfig(created)
+	if err != nil {
+		return nil, err
+	}
+	return dq, nil
+}
+
+// queueExists reports whether rootdir already holds a queue, i.e.
+// has a queue subdirectory
+func queueExists(rootdir string) bool {
+	_, err := os.Stat(filepath.Join(rootdir, "queue"))
+	return err == nil
+}
+
+// CreateOptions holds the settings for a queue created by Create
+type CreateOptions struct {
+	// PriorityBands, if set, are saved in the queue's config file
+	PriorityBands []PriorityBand
+}
+
+// Create creates a new queue in rootdir (with options in opts, if set)
+// and returns a reference to a DirQueue struct for it. ErrQueueExists
+// is returned if rootdir already holds a queue.
+func Create(rootdir string, opts *CreateOptions) (*DirQueue, error) {
+	if queueExists(rootdir) {
+		return nil, fmt.Errorf("%w: %q", ErrQueueExists, rootdir)
+	}
+	dq, err := New(rootdir)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && len(opts.PriorityBands) > 0 {
+		dq.PriorityBands = opts.PriorityBands
+		err = dq.SaveConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dq, nil
+}
+
+// Open returns a reference to a DirQueue struct for the existing queue
+// in rootdir. Unlike New, nothing is created: ErrQueueNotFound is
+// returned if rootdir or any of its queue subdirectories are missing,
+// so a mistyped path fails rather than yielding an empty queue.
+func Open(rootdir string) (*DirQueue, error) {
+	for _, subdir := range []string{"", "tmp", "data", "queue", "active"} {
+		path := filepath.Join(rootdir, subdir)
+		stat, err := os.Stat(path)
+		if err == nil && !stat.IsDir() {
+			err = fmt.Errorf("not a directory")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %s", ErrQueueNotFound, path, err.Error())
+		}
+	}
+
+	dq, err := newDirQueue(rootdir)
+	if err != nil {
+		return nil, err
+	}
+	err = dq.loadConfig(false)
+	if err != nil {
+		return nil, err
+	}
+	return dq, nil
+}
+
+// NewLazy is like New, but defers creating the queue directories and
+// config file until the first enqueue, so that constructing a DirQueue
+// that is never enqueued to (e.g. on a --help or dry run path) leaves
+// the filesystem untouched. Until then, the queue's priority bands
+// aren't loaded, and queue scans fail if the queue doesn't exist yet.
+func NewLazy(rootdir string) (*DirQueue, error) {
+	dq, err := newDirQueue(rootdir)
+	if err != nil {
+		return nil, err
+	}
+	dq.lazy = true
+	return dq, nil
+}
+
+// newDirQueue returns a reference to a DirQueue struct for rootdir,
+// without touching the filesystem
+func newDirQueue(rootdir string) (*DirQueue, error) {
+	hostname, qfhash, err := hostnameHash()
+	if err != nil {
+		return nil, err
+	}
+	return &DirQueue{
+		RootDir:   rootdir,
+		TmpDir:    filepath.Join(rootdir, "tmp"),
+		DataDir:   filepath.Join(rootdir, "data"),
+		QueueDir:  filepath.Join(rootdir, "queue"),
+		ActiveDir: filepath.Join(rootdir, "active"),
+		hostname:  hostname,
+		qfhash:    qfhash,
 	}, nil
 }
 
+// createLazy creates the directories and config file of a queue
+// constructed by NewLazy, if that hasn't been done yet
+func (dq *DirQueue) createLazy() error {
+	dq.lazyMu.Lock()
+	defer dq.lazyMu.Unlock()
+	if !dq.lazy {
+		return nil
+	}
+	created := !queueExists(dq.RootDir)
+	for _, dir := range []string{dq.TmpDir, dq.DataDir, dq.QueueDir, dq.ActiveDir} {
+		err := dq.fsop("mkdir", dir, func() error {
+			return ensureDirExists(dir)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := dq.loadConfig(created)
+	if err != nil {
+		return err
+	}
+	dq.lazy = false
+	return nil
+}
+
 // DefaultOptions returns a reference to an Options struct
 // with default member values
 func DefaultOptions() *Options {
@@ -285,65 +707,298 @@ func DefaultOptions() *Options {
 // (with options in opts, if set).
 // This is the equivalent to the perl IPC::DirQueue::enqueue_fh().
 func (dq *DirQueue) EnqueueReader(rdr io.Reader, opts *Options) error {
+	start := time.Now()
+	job, err := dq.stageJob(rdr, opts, nil)
+	if err == nil {
+		err = dq.commitJob(&job)
+	}
+	if err != nil {
+		dq.Statsd.count("enqueue.error", 1)
+		return err
+	}
+	return dq.enqueued(job, start)
+}
+
+// enqueued does the post-commit processing for job (metrics,
+// shadowing and mirroring), started at start
+func (dq *DirQueue) enqueued(job Job, start time.Time) error {
+	dq.Statsd.count("enqueue", 1)
+	dq.Statsd.timing("enqueue.time", time.Since(start))
+	dq.journal(EventEnqueue, filepath.Base(job.pathctrl))
+
+	if dq.Shadow != nil && job.opts.Metadata[shadowSampledKey] == "true" {
+		err := copyJob(job, dq.Shadow)
+		if err != nil {
+			// Shadow failures must never affect the primary queue
+			fmt.Fprintf(os.Stderr, "shadow enqueue to %q failed: %s\n",
+				dq.Shadow.RootDir, err.Error())
+		}
+	}
+
+	if dq.Mirror != nil {
+		if dq.MirrorAsync {
+			dq.mirrorAsync(job)
+		} else {
+			err := dq.mirrorJob(job)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrMirrorFailed, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// mirrorJob enqueues a copy of job into dq.Mirror
+func (dq *DirQueue) mirrorJob(job Job) error {
+	return copyJob(job, dq.Mirror)
+}
+
+// copyJob enqueues a copy of job, read back from its data file, into
+// dst
+func copyJob(job Job, dst *DirQueue) error {
+	fh, err := os.Open(job.pathdata)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return copyPayload(fh, job, dst)
+}
+
+// copyPayload enqueues a copy of job, with its stored payload read
+// from rdr, into dst
+func copyPayload(rdr io.Reader, job Job, dst *DirQueue) error {
+	return dst.EnqueueReader(rdr, job.opts.storedAs(job.opts.Metadata))
+}
+
+// storedAs returns a copy of opts for enqueueing a payload copied as
+// it is from a job with metadata meta. The transforms recorded in meta
+// have already been applied to the payload, so they're carried in
+// pretransformed (and not applied again), rather than as metadata.
+func (opts *Options) storedAs(meta map[string]string) *Options {
+	copied := *opts
+	copied.Metadata = make(map[string]string, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		if k != transformsKey {
+			copied.Metadata[k] = v
+		}
+	}
+	copied.pretransformed = meta[transformsKey]
+	return &copied
+}
+
+// withMetadatum returns a copy of opts with metadata key set to val,
+// leaving the caller's opts untouched
+func (opts *Options) withMetadatum(key, val string) *Options {
+	copied := *opts
+	copied.Metadata = make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		copied.Metadata[k] = v
+	}
+	copied.Metadata[key] = val
+	return &copied
+}
+
+// withPriority returns a copy of opts with priority pri, leaving the
+// caller's opts untouched
+func (opts *Options) withPriority(pri uint8) *Options {
+	copied := *opts
+	copied.Priority = pri
+	return &copied
+}
+
+// withDefaults returns opts with the queue's Defaults applied: a copy
+// of Defaults if opts is nil, or else a copy of opts including any
+// default metadata it doesn't set
+func (dq *DirQueue) withDefaults(opts *Options) *Options {
+	if dq.Defaults == nil {
+		if opts == nil {
+			return DefaultOptions()
+		}
+		return opts
+	}
 	if opts == nil {
-		opts = DefaultOptions()
+		copied := *dq.Defaults
+		return &copied
+	}
+	if len(dq.Defaults.Metadata) == 0 {
+		return opts
+	}
+	copied := *opts
+	copied.Metadata = make(map[string]string,
+		len(dq.Defaults.Metadata)+len(opts.Metadata))
+	for k, v := range dq.Defaults.Metadata {
+		copied.Metadata[k] = v
+	}
+	for k, v := range opts.Metadata {
+		copied.Metadata[k] = v
+	}
+	return &copied
+}
+
+// stageJob writes the data in rdr and its control file into the queue,
+// ready for the control file to be linked into the queue directory by
+// commitJob. Until then the job is invisible to consumers. Quotas are
+// checked as if the jobs already staged but not yet committed had
+// been enqueued.
+func (dq *DirQueue) stageJob(rdr io.Reader, opts *Options, staged []Job) (Job, error) {
+	err := dq.createLazy()
+	if err != nil {
+		return Job{}, err
+	}
+	opts = dq.withDefaults(opts)
+	if opts.Band != "" {
+		band, err := dq.band(opts.Band)
+		if err != nil {
+			return Job{}, err
+		}
+		if opts.Priority < band.Min || opts.Priority > band.Max {
+			opts = opts.withPriority(band.Min)
+		}
 	}
 	if opts.Priority > 99 {
-		opts.Priority = 99
+		opts = opts.withPriority(99)
+	}
+	// Transforms are recorded by the queue itself
+	if _, ok := opts.Metadata[transformsKey]; ok {
+		return Job{}, fmt.Errorf("invalid metadatum: %q is reserved", transformsKey)
+	}
+	if dq.Schema != nil {
+		err := dq.Schema.Check(opts.Metadata)
+		if err != nil {
+			return Job{}, err
+		}
+	}
+	if opts.Validate != nil {
+		err := opts.Validate(opts.Metadata, readerSize(rdr))
+		if err != nil {
+			return Job{}, err
+		}
+	}
+	if dq.Shadow != nil {
+		sampled := rand.Float64()*100 < dq.ShadowPercent
+		opts = opts.withMetadatum(shadowSampledKey, strconv.FormatBool(sampled))
+	}
+	// Payloads already transformed (i.e. copies of jobs from other
+	// queues) are stored as they are
+	var transforms []Transform
+	if opts.pretransformed != "" {
+		opts = opts.withMetadatum(transformsKey, opts.pretransformed)
+	} else if len(dq.Transforms) > 0 {
+		names, err := transformNames(dq.Transforms)
+		if err != nil {
+			return Job{}, err
+		}
+		transforms = dq.Transforms
+		opts = opts.withMetadatum(transformsKey, names)
 	}
 
-	job, err := newJob(opts)
+	// Check the job count before writing anything, so an over-quota
+	// enqueue doesn't write its whole payload first
+	err = dq.checkJobQuota(len(staged) + 1)
 	if err != nil {
-		return err
+		return Job{}, err
+	}
+
+	job, err := dq.newJob(opts)
+	if err != nil {
+		return Job{}, err
 	}
 	qfname := job.newQueueFilename(false)
 	//fmt.Printf("+ qfname: %s\n", qfname)
 	qcname := qfname
 
-	pathtmpctrl := filepath.Join(dq.TmpDir, qfname+".ctrl")
-	pathtmpdata := filepath.Join(dq.TmpDir, qfname+".data")
+	// Goroutines enqueueing in the same microsecond get the same
+	// qfname, so tmp filenames also carry a per-process sequence number
+	tmpname := qfname + "." + strconv.FormatUint(atomic.AddUint64(&tmpSeq, 1), 10)
+	pathtmpctrl := filepath.Join(dq.TmpDir, tmpname+".ctrl")
+	pathtmpdata := filepath.Join(dq.TmpDir, tmpname+".data")
 
-	outfh, err := os.Create(pathtmpdata)
+	outfh, err := dq.createTmpData(pathtmpdata)
 	if err != nil {
-		return err
+		return job, err
 	}
-	size, err := io.Copy(outfh, rdr)
+	job.pathtmpdata = pathtmpdata
+	var dst io.Writer = outfh
+	if opts.MaxWriteBytesPerSec > 0 {
+		dst = newThrottledWriter(outfh, opts.MaxWriteBytesPerSec)
+	}
+	size, err := writePayload(dst, outfh, rdr, transforms)
 	if err != nil {
-		return err
+		_ = outfh.Close()
+		job.cleanup()
+		return job, err
+	}
+	err = outfh.Close()
+	if err != nil {
+		job.cleanup()
+		return job, err
 	}
 	job.size = size
-	job.pathtmpdata = pathtmpdata
+
+	stagedSize := size
+	for _, j := range staged {
+		stagedSize += j.size
+	}
+	err = dq.checkByteQuota(stagedSize)
+	if err != nil {
+		job.cleanup()
+		return job, err
+	}
+
+	// Large payloads go to the large-object data tree
+	datadir := dq.DataDir
+	if dq.isLarge(size) {
+		err = dq.moveToLargeTmp(&job)
+		if err != nil {
+			job.cleanup()
+			return job, err
+		}
+		pathtmpdata = job.pathtmpdata
+		datadir = dq.LargeDataDir
+	}
 
 	// Create hashed datadir for qfname
 	var pathdatadir string
-	pathdatadir, qfname, err = createHashedDataDir(dq.DataDir, qfname)
+	pathdatadir, qfname, err = dq.createHashedDataDir(datadir, qfname)
 	if err != nil {
 		job.cleanup()
-		return err
+		return job, err
 	}
 
 	// Now link(2) the data tmpfile into pathdatadir
-	pathdata, err := linkIntoDir(pathtmpdata, pathdatadir, qfname, job)
+	pathdata, err := dq.linkIntoDir(pathtmpdata, pathdatadir, qfname, job)
 	if err != nil {
 		job.cleanup()
-		return err
+		return job, err
 	}
 	job.pathdata = pathdata
 
 	// Write a control file now that we know the actual data filename
-	err = createControlFile(pathtmpctrl, job)
+	err = dq.fsop("create", pathtmpctrl, func() error {
+		return createControlFile(pathtmpctrl, job)
+	})
 	if err != nil {
 		job.cleanup()
-		return err
+		return job, err
 	}
 	job.pathtmpctrl = pathtmpctrl
+	job.qcname = qcname
+
+	return job, nil
+}
 
-	// And link(2) the control file into the queue directory
-	_, err = linkIntoDir(pathtmpctrl, dq.QueueDir, qcname, job)
+// commitJob links the control file of the staged job into the queue
+// directory, making it visible to consumers. On failure all of the
+// job's files are removed.
+func (dq *DirQueue) commitJob(job *Job) error {
+	// link(2) the control file into the queue directory
+	pathctrl, err := dq.linkIntoDir(job.pathtmpctrl, dq.QueueDir, job.qcname, *job)
 	if err != nil {
 		job.cleanup()
 		return err
 	}
+	job.pathtmpctrl = ""
+	job.pathctrl = pathctrl
 
 	// Touch dq.QueueDir to indicate it's been changed and a file has been enqueued
 	// (required for some filesystems? e.g. XFS, ReiserFS)
@@ -364,6 +1019,7 @@ func (dq *DirQueue) EnqueueFile(path string, opts *Options) error {
 	if err != nil {
 		return err
 	}
+	defer fh.Close()
 	return dq.EnqueueReader(fh, opts)
 }
 
@@ -2,7 +2,9 @@ package dirqueue
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -20,6 +22,12 @@ func TestHashStringToFilename(t *testing.T) {
 	assert.Equal(t, expect, got)
 }
 
+func TestUuencodeLine(t *testing.T) {
+	assert.Equal(t, "#0V%T\n", string(uuencodeLine([]byte("Cat"))))
+	assert.Equal(t, "#-C T\n", string(uuencodeLine([]byte("604"))))
+	assert.Equal(t, "!,   \n", string(uuencodeLine([]byte("0"))))
+}
+
 func nukeTree(t *testing.T, dir string) {
 	err := os.RemoveAll(dir)
 	if err != nil {
@@ -31,6 +39,11 @@ func nukeQueue(t *testing.T, testq string) {
 	nukeTree(t, filepath.Join(testq, "tmp"))
 	nukeTree(t, filepath.Join(testq, "data"))
 	nukeTree(t, filepath.Join(testq, "queue"))
+	nukeTree(t, filepath.Join(testq, "active"))
+	nukeTree(t, filepath.Join(testq, "badctrl"))
+	nukeTree(t, filepath.Join(testq, "sched"))
+	nukeTree(t, filepath.Join(testq, configFile))
+	nukeTree(t, filepath.Join(testq, journalFile))
 }
 
 func runQueueTests(t *testing.T, testq string, filesize, priority int,
@@ -189,3 +202,108 @@ func TestEnqueueStringEmpty(t *testing.T) {
 
 	runQueueTests(t, testq, filesize, priority, metadata)
 }
+
+func TestEnqueueValidate(t *testing.T) {
+	testq := "testqueue"
+	testfile := "testdata/test1.txt"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	var gotSize int64
+	errNoUUID := errors.New("uuid required")
+	opts := DefaultOptions()
+	opts.Validate = func(meta map[string]string, size int64) error {
+		gotSize = size
+		if meta["uuid"] == "" {
+			return errNoUUID
+		}
+		return nil
+	}
+
+	err = dq.EnqueueString("Here lies the data.\n", opts)
+	assert.Equal(t, errNoUUID, err, "EnqueueString rejected")
+	assert.Equal(t, int64(20), gotSize, "string size")
+	err = dq.EnqueueFile(testfile, opts)
+	assert.Equal(t, errNoUUID, err, "EnqueueFile rejected")
+	assert.Equal(t, int64(64), gotSize, "file size")
+	err = dq.EnqueueReader(io.MultiReader(strings.NewReader("data")), opts)
+	assert.Equal(t, errNoUUID, err, "EnqueueReader rejected")
+	assert.Equal(t, int64(-1), gotSize, "unknown reader size")
+
+	// Nothing should have been written for rejected jobs
+	for _, subdir := range []string{"tmp", "queue"} {
+		f, err := filepath.Glob(filepath.Join(testq, subdir, "*"))
+		assert.Nil(t, err, subdir+" Glob")
+		assert.Equal(t, 0, len(f), "no "+subdir+" files found")
+	}
+
+	opts.Metadata["uuid"] = "65fc1b26-a6bf-489c-a75c-6c86bd6afa29"
+	err = dq.EnqueueFile(testfile, opts)
+	assert.Nil(t, err, "EnqueueFile accepted")
+}
+
+func TestEnqueueDefaults(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Defaults = &Options{
+		Priority: 20,
+		Metadata: map[string]string{"service": "billing", "env": "prod"},
+	}
+
+	err = dq.EnqueueString("defaults", nil)
+	assert.Nil(t, err, "EnqueueString nil opts")
+	opts := &Options{Priority: 60, Metadata: map[string]string{"env": "test"}}
+	err = dq.EnqueueString("overrides", opts)
+	assert.Nil(t, err, "EnqueueString with opts")
+	assert.Equal(t, 1, len(opts.Metadata), "caller metadata untouched")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 2, len(jobs), "jobs") {
+		assert.Equal(t, uint8(20), jobs[0].Priority, "default priority")
+		assert.Equal(t, "billing", jobs[0].Metadata["service"], "default service")
+		assert.Equal(t, "prod", jobs[0].Metadata["env"], "default env")
+		assert.Equal(t, uint8(60), jobs[1].Priority, "call priority")
+		assert.Equal(t, "billing", jobs[1].Metadata["service"], "merged service")
+		assert.Equal(t, "test", jobs[1].Metadata["env"], "call env wins")
+	}
+	assert.Equal(t, 2, len(dq.Defaults.Metadata), "defaults untouched")
+}
+
+func BenchmarkEnqueueString(b *testing.B) {
+	testq := "testqueue"
+	data := "Once upon a time there lived a princess who felt\nno particular inclination to marry a prince.\n"
+
+	dq, err := New(testq)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Metadata["uuid"] = "65fc1b26-a6bf-489c-a75c-6c86bd6afa29"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = dq.EnqueueString(data, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	for _, subdir := range []string{"tmp", "data", "queue"} {
+		_ = os.RemoveAll(filepath.Join(testq, subdir))
+	}
+}
+
+func BenchmarkNewQueueFilename(b *testing.B) {
+	job := Job{ts: time.Now().UTC(), opts: DefaultOptions(), qfhash: "DNjA"}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = job.newQueueFilename(false)
+	}
+}
@@ -0,0 +1,163 @@
+// Package dirqueuetest provides helpers for testing code that uses
+// dirqueue queues
+package dirqueuetest
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+// NewQueue returns a DirQueue in a new temporary directory, which is
+// removed when the test completes
+func NewQueue(t testing.TB) *dirqueue.DirQueue {
+	t.Helper()
+	dq, err := dirqueue.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating test queue: %s", err)
+	}
+	return dq
+}
+
+// Enqueue enqueues payload into dq with opts (which may be nil),
+// failing the test on error
+func Enqueue(t testing.TB, dq *dirqueue.DirQueue, payload string, opts *dirqueue.Options) {
+	t.Helper()
+	err := dq.EnqueueString(payload, opts)
+	if err != nil {
+		t.Fatalf("enqueueing test job: %s", err)
+	}
+}
+
+// EnqueueStrings enqueues each of payloads into dq with default
+// options, failing the test on error
+func EnqueueStrings(t testing.TB, dq *dirqueue.DirQueue, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		Enqueue(t, dq, payload, nil)
+	}
+}
+
+// AssertDepth checks that dq has want pending jobs
+func AssertDepth(t testing.TB, dq *dirqueue.DirQueue, want int) bool {
+	t.Helper()
+	stats, err := dq.Stats()
+	if err != nil {
+		t.Errorf("reading queue stats: %s", err)
+		return false
+	}
+	if stats.Pending != want {
+		t.Errorf("queue depth: got %d, want %d", stats.Pending, want)
+		return false
+	}
+	return true
+}
+
+// AssertJob checks that the queued job id (or the head of the queue,
+// if id is empty) has the given payload (after reversing any
+// transforms) and metadata. A nil meta skips the metadata check.
+func AssertJob(t testing.TB, dq *dirqueue.DirQueue, id, payload string, meta map[string]string) bool {
+	t.Helper()
+	info, err := dq.Peek(id)
+	if err != nil {
+		t.Errorf("peeking job %q: %s", id, err)
+		return false
+	}
+	rdr, err := dq.OpenPayload(info)
+	if err != nil {
+		t.Errorf("opening job %q data: %s", info.ID, err)
+		return false
+	}
+	data, err := ioutil.ReadAll(rdr)
+	_ = rdr.Close()
+	if err != nil {
+		t.Errorf("reading job %q data: %s", info.ID, err)
+		return false
+	}
+	ok := true
+	if string(data) != payload {
+		t.Errorf("job %q payload: got %q, want %q", info.ID, data, payload)
+		ok = false
+	}
+	if meta != nil && !reflect.DeepEqual(info.Metadata, meta) {
+		t.Errorf("job %q metadata: got %v, want %v", info.ID, info.Metadata, meta)
+		ok = false
+	}
+	return ok
+}
+
+// Clock is a deterministic clock for DirQueue.Clock. Each call to Now
+// returns the clock's time and then advances it by a microsecond (the
+// resolution of job IDs), so jobs enqueued in turn keep their order.
+// Job ages (JobInfo.Age) are still measured against the real time.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock starting at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's time, and advances it by a microsecond
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(time.Microsecond)
+	return now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Fault is a filesystem fault injected into a DirQueue by InjectFault
+type Fault struct {
+	mu    sync.Mutex
+	op    string
+	err   error
+	count int
+	hits  int
+}
+
+// InjectFault makes dq's filesystem operations op ("create", "link",
+// "mkdir" or "readdir", or "" for all of them) fail with err, for the
+// next count operations, or for every one if count is 0 or less. The
+// fault is removed when the test completes.
+func InjectFault(t testing.TB, dq *dirqueue.DirQueue, op string, err error, count int) *Fault {
+	t.Helper()
+	f := &Fault{op: op, err: err, count: count}
+	dq.FSFault = f.check
+	t.Cleanup(func() { dq.FSFault = nil })
+	return f
+}
+
+// Hits returns how many operations the fault has failed
+func (f *Fault) Hits() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits
+}
+
+// check is the DirQueue.FSFault hook for f
+func (f *Fault) check(op, path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.op != "" && op != f.op {
+		return nil
+	}
+	if f.count > 0 && f.hits >= f.count {
+		return nil
+	}
+	f.hits++
+	return f.err
+}
@@ -0,0 +1,73 @@
+package dirqueuetest
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gavincarr/dirqueue"
+)
+
+func TestHelpers(t *testing.T) {
+	dq := NewQueue(t)
+	AssertDepth(t, dq, 0)
+
+	opts := dirqueue.DefaultOptions()
+	opts.Priority = 10
+	opts.Metadata["foo"] = "bar"
+	Enqueue(t, dq, "first", opts)
+	EnqueueStrings(t, dq, "second", "third")
+
+	AssertDepth(t, dq, 3)
+	AssertJob(t, dq, "", "first", map[string]string{"foo": "bar"})
+}
+
+func TestClock(t *testing.T) {
+	dq := NewQueue(t)
+	start := time.Date(2021, 6, 7, 9, 30, 0, 0, time.UTC)
+	clock := NewClock(start)
+	dq.Clock = clock.Now
+
+	EnqueueStrings(t, dq, "first", "second")
+	clock.Advance(time.Hour)
+	Enqueue(t, dq, "third", nil)
+
+	jobs, err := dq.ListJobs(nil)
+	if err != nil {
+		t.Fatalf("ListJobs: %s", err)
+	}
+	want := []time.Time{start, start.Add(time.Microsecond),
+		start.Add(time.Hour + 2*time.Microsecond)}
+	if len(jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(jobs), len(want))
+	}
+	for i, info := range jobs {
+		if !info.EnqueueTime.Equal(want[i]) {
+			t.Errorf("job %d enqueue time: got %s, want %s", i, info.EnqueueTime, want[i])
+		}
+	}
+	AssertJob(t, dq, "", "first", nil)
+}
+
+func TestInjectFault(t *testing.T) {
+	dq := NewQueue(t)
+	fault := InjectFault(t, dq, "link", syscall.EIO, 0)
+	err := dq.EnqueueString("failed", nil)
+	if err == nil {
+		t.Errorf("EnqueueString: got no error with failing links")
+	}
+	if fault.Hits() == 0 {
+		t.Errorf("link fault not hit")
+	}
+	AssertDepth(t, dq, 0)
+
+	// Transient faults are retried, if the queue allows it
+	dq.FSRetries = 2
+	dq.FSRetryBackoff = time.Millisecond
+	fault = InjectFault(t, dq, "create", syscall.ESTALE, 2)
+	Enqueue(t, dq, "retried", nil)
+	if fault.Hits() != 2 {
+		t.Errorf("fault hits: got %d, want 2", fault.Hits())
+	}
+	AssertDepth(t, dq, 1)
+}
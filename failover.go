@@ -0,0 +1,187 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// FailoverQueue enqueues to a primary queue, falling back to each of
+// a list of alternate queues in turn (e.g. local disk when an NFS spool
+// is down) if an enqueue fails. Jobs enqueued to an alternate get a
+// "failover_origin" metadatum recording the alternate's root.
+//
+// Only filesystem and availability errors (including FSTimeoutErrors)
+// trigger failover. Policy rejections, like quota errors, invalid
+// options or metadata and SchemaErrors, are returned directly, since
+// an alternate might not enforce the same policy.
+type FailoverQueue struct {
+	Primary    *DirQueue
+	Alternates []*DirQueue
+
+	// MigrateBack, if set, moves pending jobs from the alternates to
+	// the primary (via Migrate) after the first successful primary
+	// enqueue following a failover
+	MigrateBack bool
+
+	failedOver int32
+}
+
+// NewFailoverQueue returns a reference to a FailoverQueue struct with
+// the queue in primary as its primary, and queues in each of
+// alternates as fallbacks. Queue directories are created lazily (see
+// NewLazy), so an unavailable queue doesn't fail construction.
+func NewFailoverQueue(primary string, alternates ...string) (*FailoverQueue, error) {
+	if len(alternates) == 0 {
+		return nil, errors.New("no alternate root directories given")
+	}
+	fq := &FailoverQueue{}
+	var err error
+	fq.Primary, err = NewLazy(primary)
+	if err != nil {
+		return nil, err
+	}
+	for _, rootdir := range alternates {
+		dq, err := NewLazy(rootdir)
+		if err != nil {
+			return nil, err
+		}
+		fq.Alternates = append(fq.Alternates, dq)
+	}
+	return fq, nil
+}
+
+// failoverOptions returns a copy of opts with a failover_origin
+// metadatum for dq
+func failoverOptions(opts *Options, dq *DirQueue) *Options {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	return opts.withMetadatum(failoverOriginKey, dq.RootDir)
+}
+
+// isUnavailable reports whether err is a filesystem or availability
+// failure, rather than a policy rejection
+func isUnavailable(err error) bool {
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	var sysErr *os.SyscallError
+	var errno syscall.Errno
+	return errors.As(err, &pathErr) || errors.As(err, &linkErr) ||
+		errors.As(err, &sysErr) || errors.As(err, &errno) ||
+		errors.Is(err, ErrFSTimeout)
+}
+
+// EnqueueReader enqueues the data in rdr into the primary queue,
+// falling back to the alternates on failure (with options in opts, if
+// set). Failover requires rereading rdr, so is only attempted if rdr
+// is an io.Seeker.
+func (fq *FailoverQueue) EnqueueReader(rdr io.Reader, opts *Options) error {
+	seeker, canSeek := rdr.(io.Seeker)
+	var offset int64
+	if canSeek {
+		var err error
+		offset, err = seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			canSeek = false
+		}
+	}
+
+	err := fq.Primary.EnqueueReader(rdr, opts)
+	if err == nil || errors.Is(err, ErrMirrorFailed) {
+		if atomic.LoadInt32(&fq.failedOver) == 1 && fq.MigrateBack {
+			go fq.migrateBack()
+		}
+		return err
+	}
+	if !isUnavailable(err) || !canSeek {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "enqueue to %q failed, failing over: %s\n",
+		fq.Primary.RootDir, err.Error())
+
+	for _, dq := range fq.Alternates {
+		_, serr := seeker.Seek(offset, io.SeekStart)
+		if serr != nil {
+			return err
+		}
+		err = dq.EnqueueReader(rdr, failoverOptions(opts, dq))
+		if err == nil || errors.Is(err, ErrMirrorFailed) {
+			atomic.StoreInt32(&fq.failedOver, 1)
+			return err
+		}
+		if !isUnavailable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// EnqueueFile enqueues the data file in path into the primary queue,
+// falling back to the alternates on failure (with options in opts, if
+// set)
+func (fq *FailoverQueue) EnqueueFile(path string, opts *Options) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return fq.EnqueueReader(fh, opts)
+}
+
+// EnqueueString enqueues the data in string into the primary queue,
+// falling back to the alternates on failure (with options in opts, if
+// set)
+func (fq *FailoverQueue) EnqueueString(data string, opts *Options) error {
+	return fq.EnqueueReader(strings.NewReader(data), opts)
+}
+
+// migrateBack runs Migrate after a failover, warning on failure
+func (fq *FailoverQueue) migrateBack() {
+	if !atomic.CompareAndSwapInt32(&fq.failedOver, 1, 0) {
+		// Already migrating
+		return
+	}
+	_, err := fq.Migrate()
+	if err != nil {
+		atomic.StoreInt32(&fq.failedOver, 1)
+		fmt.Fprintf(os.Stderr, "failover migration to %q failed: %s\n",
+			fq.Primary.RootDir, err.Error())
+	}
+}
+
+// Migrate moves the pending jobs in the alternate queues to the
+// primary, returning the number moved. Each job is claimed (locked in
+// the alternate's active directory, as an IPC::DirQueue consumer
+// would) before being copied, so it can't also be processed from the
+// alternate.
+func (fq *FailoverQueue) Migrate() (int, error) {
+	moved := 0
+	for _, dq := range fq.Alternates {
+		if _, err := os.Stat(dq.QueueDir); os.IsNotExist(err) {
+			// Never used
+			continue
+		}
+		jobs, err := dq.ListJobs(func(info *JobInfo) bool {
+			return info.State == StatePending
+		})
+		if err != nil {
+			return moved, err
+		}
+		for i := range jobs {
+			err = dq.MoveJob(&jobs[i], fq.Primary)
+			if err == ErrJobNotPending {
+				continue
+			}
+			if err != nil && !errors.Is(err, ErrMirrorFailed) {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
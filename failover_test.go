@@ -0,0 +1,90 @@
+package dirqueue
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverQueue(t *testing.T) {
+	dir := t.TempDir()
+	// The primary is unavailable while its parent is a plain file
+	blocker := filepath.Join(dir, "nfs")
+	err := ioutil.WriteFile(blocker, nil, 0644)
+	assert.Nil(t, err, "write blocker")
+
+	fq, err := NewFailoverQueue(filepath.Join(blocker, "q"), filepath.Join(dir, "local"))
+	assert.Nil(t, err, "NewFailoverQueue")
+	alt := fq.Alternates[0]
+
+	err = fq.EnqueueString("one", &Options{Priority: 10,
+		Metadata: map[string]string{"foo": "bar"}})
+	assert.Nil(t, err, "EnqueueString fails over")
+	jobs, err := alt.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs alternate")
+	if assert.Equal(t, 1, len(jobs), "job on alternate") {
+		assert.Equal(t, alt.RootDir, jobs[0].Metadata["failover_origin"], "origin recorded")
+		assert.Equal(t, "bar", jobs[0].Metadata["foo"], "metadata kept")
+	}
+
+	// Unseekable readers can't fail over
+	err = fq.EnqueueReader(io.MultiReader(strings.NewReader("three")), nil)
+	assert.NotNil(t, err, "EnqueueReader unseekable")
+
+	// Quota errors don't fail over
+	fq2, err := NewFailoverQueue(filepath.Join(dir, "full"), filepath.Join(dir, "spare"))
+	assert.Nil(t, err, "NewFailoverQueue")
+	fq2.Primary.MaxJobs = 1
+	err = fq2.EnqueueString("fits", nil)
+	assert.Nil(t, err, "EnqueueString within quota")
+	err = fq2.EnqueueString("over", nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "quota error returned")
+	_, err = os.Stat(filepath.Join(dir, "spare"))
+	assert.True(t, os.IsNotExist(err), "spare unused")
+
+	// Nor do other policy rejections
+	rejected := errors.New("rejected")
+	err = fq2.EnqueueString("invalid", &Options{
+		Validate: func(meta map[string]string, size int64) error { return rejected },
+	})
+	assert.True(t, errors.Is(err, rejected), "validation error returned")
+	fq2.Primary.Schema = &Schema{Required: []string{"owner"}}
+	err = fq2.EnqueueString("unowned", nil)
+	assert.True(t, errors.Is(err, ErrInvalidMetadata), "schema error returned")
+	_, err = os.Stat(filepath.Join(dir, "spare"))
+	assert.True(t, os.IsNotExist(err), "spare still unused")
+
+	// Once the primary recovers, jobs migrate back
+	err = os.Remove(blocker)
+	assert.Nil(t, err, "remove blocker")
+	fq.MigrateBack = true
+	err = fq.EnqueueString("two", nil)
+	assert.Nil(t, err, "EnqueueString to recovered primary")
+	var count int
+	for i := 0; i < 100; i++ {
+		count, err = fq.Primary.PendingCount()
+		assert.Nil(t, err, "PendingCount primary")
+		if count == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, count, "job migrated to primary")
+	stats, err := alt.Stats()
+	assert.Nil(t, err, "Stats alternate")
+	assert.Equal(t, 0, stats.Pending+stats.Active, "alternate empty")
+
+	jobs, err = fq.Primary.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs primary")
+	if assert.Equal(t, 2, len(jobs), "two jobs on primary") {
+		assert.Equal(t, uint8(10), jobs[0].Priority, "priority kept")
+		assert.Equal(t, alt.RootDir, jobs[0].Metadata["failover_origin"], "origin kept")
+	}
+}
@@ -0,0 +1,123 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrFSTimeout is returned (wrapped in an FSTimeoutError) when a
+// filesystem operation takes longer than the queue's OpTimeout
+var ErrFSTimeout = errors.New("filesystem operation timed out")
+
+// FSTimeoutError describes a filesystem operation that timed out
+type FSTimeoutError struct {
+	Op      string // "create", "link", "mkdir" or "readdir"
+	Path    string
+	Timeout time.Duration
+}
+
+func (e *FSTimeoutError) Error() string {
+	return fmt.Sprintf("%s %q: %s after %s", e.Op, e.Path, ErrFSTimeout, e.Timeout)
+}
+
+// Unwrap returns ErrFSTimeout, so FSTimeoutErrors match it with errors.Is
+func (e *FSTimeoutError) Unwrap() error {
+	return ErrFSTimeout
+}
+
+// defaultFSRetryBackoff is the initial wait between retries if
+// FSRetryBackoff isn't set
+const defaultFSRetryBackoff = 10 * time.Millisecond
+
+// isTransientFSError reports whether err is one network filesystems
+// return transiently, like a stale NFS file handle
+func isTransientFSError(err error) bool {
+	return errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.EIO)
+}
+
+// fsop runs fn, which performs filesystem operation op on path. If op
+// is idempotent (anything but a link, which may have succeeded despite
+// returning an error) and fn fails with ESTALE or EIO, it is retried
+// up to dq.FSRetries times with doubling backoff.
+func (dq *DirQueue) fsop(op, path string, fn func() error) error {
+	backoff := dq.FSRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultFSRetryBackoff
+	}
+	for retry := 0; ; retry++ {
+		err := dq.fsopOnce(op, path, fn)
+		if err == nil || op == "link" || retry >= dq.FSRetries ||
+			!isTransientFSError(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// openFile is os.OpenFile, replaceable in tests
+var openFile = os.OpenFile
+
+// createTmpData creates the tmp file path for a new job's payload.
+// The name is unique to this enqueue, so it is created exclusively on
+// the first attempt, but a retry may find the file created by an
+// attempt that then failed (e.g. with EIO), which is ours to reuse.
+func (dq *DirQueue) createTmpData(path string) (*os.File, error) {
+	var fh *os.File
+	attempt := 0
+	err := dq.fsop("create", path, func() error {
+		flags := os.O_RDWR | os.O_CREATE | os.O_EXCL
+		if attempt > 0 {
+			flags = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		}
+		attempt++
+		var err error
+		fh, err = openFile(path, flags, 0666)
+		return err
+	})
+	return fh, err
+}
+
+// fsopOnce runs fn once, unless dq.FSFault fails it first. If
+// dq.OpTimeout is set and fn hasn't returned within it, an
+// FSTimeoutError is returned. A blocked system call can't be
+// interrupted, so fn is left to finish in the background, and callers
+// must not use anything fn sets after a timeout.
+func (dq *DirQueue) fsopOnce(op, path string, fn func() error) error {
+	if dq.FSFault != nil {
+		err := dq.FSFault(op, path)
+		if err != nil {
+			return err
+		}
+	}
+	if dq.OpTimeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	timer := time.NewTimer(dq.OpTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return &FSTimeoutError{Op: op, Path: path, Timeout: dq.OpTimeout}
+	}
+}
+
+// entries returns the names of the regular files in dir, as for
+// queueEntries, subject to dq.OpTimeout
+func (dq *DirQueue) entries(dir string) ([]string, error) {
+	var names []string
+	err := dq.fsop("readdir", dir, func() error {
+		var err error
+		names, err = queueEntries(dir)
+		return err
+	})
+	return names, err
+}
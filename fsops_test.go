@@ -0,0 +1,125 @@
+package dirqueue
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSOpTimeout(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	// No timeout by default
+	err = dq.fsop("link", "/x", func() error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	assert.Nil(t, err, "fsop without timeout")
+
+	dq.OpTimeout = 5 * time.Millisecond
+	release := make(chan struct{})
+	err = dq.fsop("link", "/x", func() error {
+		<-release
+		return nil
+	})
+	close(release)
+	var te *FSTimeoutError
+	if assert.True(t, errors.As(err, &te), "fsop FSTimeoutError") {
+		assert.Equal(t, "link", te.Op, "FSTimeoutError.Op")
+		assert.Equal(t, "/x", te.Path, "FSTimeoutError.Path")
+	}
+	assert.True(t, errors.Is(err, ErrFSTimeout), "fsop ErrFSTimeout")
+
+	errBoom := errors.New("boom")
+	err = dq.fsop("link", "/x", func() error { return errBoom })
+	assert.Equal(t, errBoom, err, "fsop error passed through")
+
+	// Normal operations complete within the timeout
+	dq.OpTimeout = time.Second
+	err = dq.EnqueueString("timely", nil)
+	assert.Nil(t, err, "EnqueueString with OpTimeout")
+	stats, err := dq.Stats()
+	assert.Nil(t, err, "Stats with OpTimeout")
+	assert.Equal(t, 1, stats.Pending, "one job pending")
+}
+
+func TestFSOpRetry(t *testing.T) {
+	dq := &DirQueue{FSRetryBackoff: time.Millisecond}
+
+	calls := 0
+	flaky := func() error {
+		calls++
+		if calls <= 2 {
+			return &os.PathError{Op: "open", Path: "/x", Err: syscall.ESTALE}
+		}
+		return nil
+	}
+
+	// No retries by default
+	err := dq.fsop("create", "/x", flaky)
+	assert.True(t, errors.Is(err, syscall.ESTALE), "fsop without retries")
+	assert.Equal(t, 1, calls, "one call")
+
+	calls = 0
+	dq.FSRetries = 2
+	err = dq.fsop("create", "/x", flaky)
+	assert.Nil(t, err, "fsop retried")
+	assert.Equal(t, 3, calls, "three calls")
+
+	calls = 0
+	dq.FSRetries = 1
+	err = dq.fsop("readdir", "/x", flaky)
+	assert.True(t, errors.Is(err, syscall.ESTALE), "fsop out of retries")
+	assert.Equal(t, 2, calls, "two calls")
+
+	// Links and non-transient errors aren't retried
+	calls = 0
+	dq.FSRetries = 5
+	err = dq.fsop("link", "/x", flaky)
+	assert.NotNil(t, err, "fsop link")
+	assert.Equal(t, 1, calls, "link not retried")
+
+	calls = 0
+	err = dq.fsop("create", "/x", func() error {
+		calls++
+		return os.ErrPermission
+	})
+	assert.Equal(t, os.ErrPermission, err, "fsop permission error")
+	assert.Equal(t, 1, calls, "permission error not retried")
+}
+
+func TestCreateTmpDataRetry(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.FSRetries = 1
+	dq.FSRetryBackoff = time.Millisecond
+
+	// The first create succeeds on the server, but reports EIO
+	calls := 0
+	openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		calls++
+		fh, err := os.OpenFile(name, flag, perm)
+		if calls == 1 && err == nil {
+			fh.Close()
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EIO}
+		}
+		return fh, err
+	}
+	defer func() { openFile = os.OpenFile }()
+
+	err = dq.EnqueueString("data", nil)
+	assert.Nil(t, err, "EnqueueString after EIO")
+	assert.Equal(t, 2, calls, "create retried")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 1, count, "job enqueued")
+}
@@ -0,0 +1,169 @@
+package dirqueue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RemoveStaleTmp removes files in the queue's tmp directories (and
+// that of LargeDataDir, if set) not modified for olderThan, returning
+// how many were removed. Tmp files only outlive an enqueue if its
+// producer crashed, so olderThan need only exceed the longest enqueue
+// or EnqueueTx transaction.
+func (dq *DirQueue) RemoveStaleTmp(olderThan time.Duration) (int, error) {
+	dirs := []string{dq.TmpDir}
+	if dq.LargeDataDir != "" {
+		dirs = append(dirs, filepath.Join(dq.LargeDataDir, "tmp"))
+	}
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	for _, dir := range dirs {
+		names, err := dq.entries(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			fi, err := os.Lstat(path)
+			if err != nil || !fi.Mode().IsRegular() || !fi.ModTime().Before(cutoff) {
+				continue
+			}
+			err = os.Remove(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// RemoveOrphanedData removes data files in DataDir not modified for
+// olderThan that no control file refers to, returning how many were
+// removed. These are left behind by producers or consumers that
+// crashed between writing or removing a job's files. LargeDataDir is
+// left alone, since it may be shared with other queues.
+//
+// Control files record absolute data paths, which differ between
+// hosts (or symlinks) reaching the queue by different paths, so data
+// files are matched by their path under the data directory. If any
+// control file's data path can't be read, nothing is removed.
+func (dq *DirQueue) RemoveOrphanedData(olderThan time.Duration) (int, error) {
+	refs, err := dq.dataRefs()
+	if err != nil || refs == nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var orphans []string
+	err = filepath.Walk(dq.DataDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || !fi.ModTime().Before(cutoff) {
+			return nil
+		}
+		rel, err := filepath.Rel(dq.DataDir, path)
+		if err != nil {
+			return err
+		}
+		if !refs[filepath.ToSlash(rel)] {
+			orphans = append(orphans, path)
+		}
+		return nil
+	})
+	if err != nil || len(orphans) == 0 {
+		return 0, err
+	}
+
+	// Check the references again, in case a control file was being
+	// renamed (e.g. by Reprioritize) during the first scan
+	refs, err = dq.dataRefs()
+	if err != nil || refs == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range orphans {
+		rel, _ := filepath.Rel(dq.DataDir, path)
+		if refs[filepath.ToSlash(rel)] {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// dataRefs returns the set of data files referred to by the control
+// files in the queue and tmp directories (including malformed ones),
+// keyed by their hashed "l1/l2/name" path under a data directory. A
+// nil set is returned if any control file has no usable data path.
+func (dq *DirQueue) dataRefs() (map[string]bool, error) {
+	refs := make(map[string]bool)
+	for _, dir := range []string{dq.QueueDir, dq.TmpDir} {
+		names, err := dq.entries(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if dir == dq.TmpDir && !strings.HasSuffix(name, ".ctrl") {
+				continue
+			}
+			pathdata, err := ctrlDataPath(filepath.Join(dir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			key := dataRefKey(pathdata)
+			if key == "" {
+				return nil, nil
+			}
+			refs[key] = true
+		}
+	}
+	return refs, nil
+}
+
+// ctrlDataPath returns the data file path from the QDFN line of the
+// control file in path, or "" if it has none
+func ctrlDataPath(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var pathdata string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "QDFN: ") {
+			pathdata = strings.TrimPrefix(line, "QDFN: ")
+		}
+	}
+	return pathdata, nil
+}
+
+// dataRefKey returns the last three components ("l1/l2/name") of
+// the data path pathdata, or "" if it has fewer
+func dataRefKey(pathdata string) string {
+	parts := strings.Split(filepath.ToSlash(pathdata), "/")
+	if len(parts) < 4 || parts[len(parts)-1] == "" {
+		return ""
+	}
+	return strings.Join(parts[len(parts)-3:], "/")
+}
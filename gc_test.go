@@ -0,0 +1,127 @@
+package dirqueue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeAged writes a file at path with a modification time age ago
+func writeAged(t *testing.T, path string, age time.Duration) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("x"), 0644), "WriteFile")
+	old := time.Now().Add(-age)
+	assert.Nil(t, os.Chtimes(path, old, old), "Chtimes")
+}
+
+func TestRemoveStaleTmp(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.LargeDataDir = t.TempDir()
+
+	stale := filepath.Join(dq.TmpDir, "stale.data")
+	largeStale := filepath.Join(dq.LargeDataDir, "tmp", "stale.data")
+	fresh := filepath.Join(dq.TmpDir, "fresh.data")
+	writeAged(t, stale, 2*time.Hour)
+	writeAged(t, largeStale, 2*time.Hour)
+	writeAged(t, fresh, time.Minute)
+
+	removed, err := dq.RemoveStaleTmp(time.Hour)
+	assert.Nil(t, err, "RemoveStaleTmp")
+	assert.Equal(t, 2, removed, "stale tmp files removed")
+	for _, path := range []string{stale, largeStale} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "removed "+path)
+	}
+	_, err = os.Stat(fresh)
+	assert.Nil(t, err, "fresh tmp file kept")
+}
+
+func TestRemoveOrphanedData(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+
+	// An old job's data is still referenced by its control file
+	err = dq.EnqueueString("queued", nil)
+	assert.Nil(t, err, "EnqueueString")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 1, len(jobs), "one job") {
+		return
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(jobs[0].DataPath, old, old), "Chtimes")
+
+	// As is the data of a job staged in an open transaction
+	staged := filepath.Join(dq.DataDir, "aa", "bb", "staged")
+	writeAged(t, staged, 2*time.Hour)
+	pathstaged, err := filepath.Abs(staged)
+	assert.Nil(t, err, "Abs")
+	err = ioutil.WriteFile(filepath.Join(dq.TmpDir, "staged.ctrl"),
+		[]byte("QDFN: "+pathstaged+"\n"), 0644)
+	assert.Nil(t, err, "WriteFile")
+
+	orphan := filepath.Join(dq.DataDir, "cc", "dd", "orphan")
+	fresh := filepath.Join(dq.DataDir, "cc", "dd", "fresh")
+	writeAged(t, orphan, 2*time.Hour)
+	writeAged(t, fresh, time.Minute)
+
+	removed, err := dq.RemoveOrphanedData(time.Hour)
+	assert.Nil(t, err, "RemoveOrphanedData")
+	assert.Equal(t, 1, removed, "orphan removed")
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err), "orphan gone")
+	for _, path := range []string{jobs[0].DataPath, staged, fresh} {
+		_, err = os.Stat(path)
+		assert.Nil(t, err, "kept "+path)
+	}
+
+	job, err := dq.PickupQueuedJob()
+	assert.Nil(t, err, "PickupQueuedJob")
+	data, err := job.Data()
+	assert.Nil(t, err, "Data")
+	assert.Equal(t, "queued", string(data), "payload intact")
+}
+
+func TestRemoveOrphanedDataOtherPath(t *testing.T) {
+	// Producers may reach the queue by another path (e.g. a symlink
+	// or a different mount point), recording data paths under it
+	base := t.TempDir()
+	real := filepath.Join(base, "real")
+	assert.Nil(t, os.Mkdir(real, 0755), "Mkdir")
+	assert.Nil(t, os.Symlink(real, filepath.Join(base, "link")), "Symlink")
+	producer, err := New(filepath.Join(base, "link", "q"))
+	assert.Nil(t, err, "producer constructor")
+	err = producer.EnqueueString("queued", nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	dq, err := New(filepath.Join(real, "q"))
+	assert.Nil(t, err, "constructor")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 1, len(jobs), "one job") {
+		return
+	}
+	pathdata := filepath.Join(dq.DataDir, dataRefKey(jobs[0].DataPath))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(pathdata, old, old), "Chtimes")
+
+	removed, err := dq.RemoveOrphanedData(time.Hour)
+	assert.Nil(t, err, "RemoveOrphanedData")
+	assert.Equal(t, 0, removed, "live data kept")
+
+	// A control file without a usable data path stops any removal
+	orphan := filepath.Join(dq.DataDir, "cc", "dd", "orphan")
+	writeAged(t, orphan, 2*time.Hour)
+	err = ioutil.WriteFile(filepath.Join(dq.TmpDir, "bad.ctrl"), []byte("QDSB: 1\n"), 0644)
+	assert.Nil(t, err, "WriteFile")
+	removed, err = dq.RemoveOrphanedData(time.Hour)
+	assert.Nil(t, err, "RemoveOrphanedData unresolved")
+	assert.Equal(t, 0, removed, "nothing removed")
+	_, err = os.Stat(orphan)
+	assert.Nil(t, err, "orphan kept")
+}
@@ -2,8 +2,4 @@ module github.com/gavincarr/dirqueue
 
 go 1.16
 
-require (
-	github.com/stretchr/testify v1.7.0 // indirect
-	github.com/tejainece/hexutils v0.0.0-20160712025500-f865a37ec9c1 // indirect
-	github.com/tejainece/uu v0.0.0-20160709193422-afdda8302cdf // indirect
-)
+require github.com/stretchr/testify v1.7.0
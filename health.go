@@ -0,0 +1,99 @@
+package dirqueue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HealthCheck is the result of one of the checks made by Health
+type HealthCheck struct {
+	Name     string // "tmp write", "tmp link", "tmp remove" or "queue read"
+	Err      error  // nil if the check passed
+	Duration time.Duration
+}
+
+// HealthStatus is the result of a Health self-test
+type HealthStatus struct {
+	OK     bool
+	Checks []HealthCheck
+}
+
+// Err returns the error from the first failed check, or nil if all
+// checks passed
+func (h *HealthStatus) Err() error {
+	for _, c := range h.Checks {
+		if c.Err != nil {
+			return fmt.Errorf("%s: %w", c.Name, c.Err)
+		}
+	}
+	return nil
+}
+
+// runCheck runs fn as the named check, giving up with ctx.Err() if ctx
+// is done first
+func runCheck(ctx context.Context, name string, fn func() error) HealthCheck {
+	start := time.Now()
+	if err := ctx.Err(); err != nil {
+		return HealthCheck{Name: name, Err: err}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return HealthCheck{Name: name, Err: err, Duration: time.Since(start)}
+}
+
+// Health self-tests the queue by writing, hard linking and removing a
+// small file in TmpDir (as every enqueue does), and listing QueueDir.
+// Checks stop at the first failure, or when ctx is done. It is cheap
+// enough for use in readiness probes.
+func (dq *DirQueue) Health(ctx context.Context) *HealthStatus {
+	path := filepath.Join(dq.TmpDir,
+		fmt.Sprintf(".health.%s.%d.%d", dq.hostname, os.Getpid(), time.Now().UnixNano()))
+	pathlink := path + ".link"
+
+	status := &HealthStatus{}
+	for _, check := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"tmp write", func() error {
+			return ioutil.WriteFile(path, []byte("ok\n"), 0666)
+		}},
+		{"tmp link", func() error {
+			return os.Link(path, pathlink)
+		}},
+		{"tmp remove", func() error {
+			err := os.Remove(pathlink)
+			if err != nil {
+				return err
+			}
+			return os.Remove(path)
+		}},
+		{"queue read", func() error {
+			_, err := queueEntries(dq.QueueDir)
+			return err
+		}},
+	} {
+		result := runCheck(ctx, check.name, check.fn)
+		status.Checks = append(status.Checks, result)
+		if result.Err != nil {
+			// Tidy up after a failed link or remove
+			_ = os.Remove(pathlink)
+			_ = os.Remove(path)
+			return status
+		}
+	}
+	status.OK = true
+	return status
+}
@@ -0,0 +1,43 @@
+package dirqueue
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	status := dq.Health(context.Background())
+	assert.True(t, status.OK, "healthy")
+	assert.Nil(t, status.Err(), "no error")
+	assert.Equal(t, 4, len(status.Checks), "all checks run")
+
+	// Nothing is left behind in tmp
+	entries, err := os.ReadDir(dq.TmpDir)
+	assert.Nil(t, err, "ReadDir")
+	assert.Equal(t, 0, len(entries), "tmp empty")
+
+	// A cancelled context fails the first check
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status = dq.Health(ctx)
+	assert.False(t, status.OK, "cancelled not healthy")
+
+	// A missing queue dir fails
+	dq.QueueDir = dq.QueueDir + ".missing"
+	status = dq.Health(context.Background())
+	assert.False(t, status.OK, "missing queue dir not healthy")
+	if assert.Equal(t, 4, len(status.Checks), "all checks run") {
+		assert.Equal(t, "queue read", status.Checks[3].Name, "failed check")
+	}
+	assert.NotNil(t, status.Err(), "error")
+}
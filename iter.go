@@ -0,0 +1,27 @@
+//go:build go1.23
+
+package dirqueue
+
+import "iter"
+
+// Jobs returns an iterator over the JobInfo of each pending and active
+// job in the queue that matches filter (or all jobs, if filter is nil),
+// in pickup order. Control files are read lazily as the loop advances,
+// so breaking out early avoids reading the rest. If the scan fails, the
+// error is yielded (with a zero JobInfo) and iteration ends.
+func (dq *DirQueue) Jobs(filter JobFilter) iter.Seq2[JobInfo, error] {
+	return func(yield func(JobInfo, error) bool) {
+		err := dq.scanJobs(func(info *JobInfo) error {
+			if filter != nil && !filter(info) {
+				return nil
+			}
+			if !yield(*info, nil) {
+				return SkipRest
+			}
+			return nil
+		})
+		if err != nil && err != SkipRest {
+			yield(JobInfo{}, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package dirqueue
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobs(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	for i := 0; i < 5; i++ {
+		err = dq.EnqueueString(fmt.Sprintf("job %d", i), &Options{Priority: uint8(10 + i)})
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	var priorities []uint8
+	for info, err := range dq.Jobs(nil) {
+		assert.Nil(t, err, "Jobs")
+		priorities = append(priorities, info.Priority)
+	}
+	assert.Equal(t, []uint8{10, 11, 12, 13, 14}, priorities, "all jobs in order")
+
+	// Filtered, with early exit
+	priorities = nil
+	odd := func(info *JobInfo) bool { return info.Priority%2 == 1 }
+	for info, err := range dq.Jobs(odd) {
+		assert.Nil(t, err, "Jobs")
+		priorities = append(priorities, info.Priority)
+		break
+	}
+	assert.Equal(t, []uint8{11}, priorities, "first odd job")
+
+	// Scan errors are yielded
+	bad := &DirQueue{QueueDir: "/nonexistent/queue", ActiveDir: "/nonexistent/active"}
+	count := 0
+	for _, err := range bad.Jobs(nil) {
+		assert.NotNil(t, err, "Jobs error")
+		count++
+	}
+	assert.Equal(t, 1, count, "one error yielded")
+}
@@ -0,0 +1,235 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoJobs is returned when a queue has no pending jobs
+var ErrNoJobs = errors.New("no jobs in queue")
+
+// JobState is the state of a queued job
+type JobState string
+
+const (
+	StatePending JobState = "pending"
+	StateActive  JobState = "active" // locked by a consumer
+)
+
+// JobInfo describes a queued job, as recorded in its control file
+type JobInfo struct {
+	ID          string // control filename
+	State       JobState
+	Priority    uint8
+	Band        string // name of the queue's PriorityBand for Priority, if any
+	EnqueueTime time.Time
+	Size        int64
+	Hostname    string
+	DataPath    string
+	Metadata    map[string]string
+}
+
+// JobFilter selects jobs for ListJobs
+type JobFilter func(info *JobInfo) bool
+
+// Age returns how long ago the job was enqueued
+func (info *JobInfo) Age() time.Duration {
+	return time.Since(info.EnqueueTime)
+}
+
+// Options returns enqueue Options carrying over the job's priority,
+// enqueue time and metadata, for requeueing it or chaining a follow-up
+// job that keeps its place in the queue. Adjust the returned Options
+// to change any of them. The transforms metadatum is left out, since
+// it describes the stored payload rather than the job.
+func (info *JobInfo) Options() *Options {
+	opts := DefaultOptions()
+	opts.Priority = info.Priority
+	opts.EnqueueTime = info.EnqueueTime
+	for k, v := range info.Metadata {
+		if k != transformsKey {
+			opts.Metadata[k] = v
+		}
+	}
+	return opts
+}
+
+// newJobInfo builds a JobInfo for the job id from its parsed
+// control data ctrl
+func (dq *DirQueue) newJobInfo(id string, state JobState, ctrl map[string]string) *JobInfo {
+	info := &JobInfo{
+		ID:          id,
+		State:       state,
+		EnqueueTime: ctrlEnqueueTime(ctrl),
+		Hostname:    ctrl["QSHN"],
+		DataPath:    ctrl["QDFN"],
+		Metadata:    make(map[string]string),
+	}
+	info.Priority, _ = qfnamePriority(id)
+	info.Band = dq.BandName(info.Priority)
+	info.Size, _ = strconv.ParseInt(ctrl["QDSB"], 10, 64)
+	for k, v := range ctrl {
+		if !reControlKeyFormat.MatchString(k) {
+			info.Metadata[k] = v
+		}
+	}
+	return info
+}
+
+// Peek returns the JobInfo for the queued job with the given id,
+// without claiming it. If id is empty, the job at the head of the
+// queue (the next pending job a consumer would pick up) is returned.
+func (dq *DirQueue) Peek(id string) (*JobInfo, error) {
+	if id == "" {
+		queued, err := dq.entries(dq.QueueDir)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(queued)
+		for _, qfname := range queued {
+			_, err = os.Stat(filepath.Join(dq.ActiveDir, qfname))
+			if os.IsNotExist(err) {
+				id = qfname
+				break
+			}
+		}
+		if id == "" {
+			return nil, ErrNoJobs
+		}
+	}
+
+	if strings.ContainsRune(id, filepath.Separator) {
+		return nil, fmt.Errorf("invalid job id %q", id)
+	}
+	ctrl, err := readControlFile(filepath.Join(dq.QueueDir, id))
+	if err != nil {
+		return nil, err
+	}
+	state := StatePending
+	if _, err = os.Stat(filepath.Join(dq.ActiveDir, id)); err == nil {
+		state = StateActive
+	}
+	return dq.newJobInfo(id, state, ctrl), nil
+}
+
+// scanJobs calls fn with the JobInfo of each job in the queue, in
+// pickup order. Control files that disappear during the scan (e.g.
+// because a consumer finished them) are skipped, and malformed ones
+// are quarantined.
+func (dq *DirQueue) scanJobs(fn func(info *JobInfo) error) error {
+	active, err := dq.entries(dq.ActiveDir)
+	if err != nil {
+		return err
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, name := range active {
+		isActive[name] = true
+	}
+
+	queued, err := dq.entries(dq.QueueDir)
+	if err != nil {
+		return err
+	}
+	sort.Strings(queued)
+	for _, qfname := range queued {
+		state := StatePending
+		if isActive[qfname] {
+			state = StateActive
+		}
+		ctrl, err := readControlFile(filepath.Join(dq.QueueDir, qfname))
+		if errors.Is(err, ErrBadControlFile) {
+			// Quarantine pending jobs, but leave active ones to
+			// whichever consumer has them
+			if state == StatePending {
+				dq.quarantine(qfname)
+			}
+			continue
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		err = fn(dq.newJobInfo(qfname, state, ctrl))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SkipRest can be returned from a Walk function to stop the walk
+// early. Walk then returns nil.
+var SkipRest = errors.New("skip remaining jobs")
+
+// WalkFunc is called by Walk for each job in the queue
+type WalkFunc func(info *JobInfo) error
+
+// Walk calls fn with the JobInfo of each pending and active job in the
+// queue, in pickup order, without claiming anything. If fn returns
+// SkipRest the walk stops and Walk returns nil; any other error stops
+// the walk and is returned wrapped with the job id.
+//
+// The queue may change during the walk. Jobs are taken from a listing
+// made when the walk starts, so jobs enqueued after that are not seen,
+// and jobs that finish (or are removed) before fn reaches them are
+// skipped. A job seen as pending may have been picked up by the time
+// fn is called.
+func (dq *DirQueue) Walk(fn WalkFunc) error {
+	err := dq.scanJobs(func(info *JobInfo) error {
+		err := fn(info)
+		if err != nil && err != SkipRest {
+			return fmt.Errorf("walk %s: %w", info.ID, err)
+		}
+		return err
+	})
+	if err == SkipRest {
+		return nil
+	}
+	return err
+}
+
+// ListJobs returns the JobInfo of each pending and active job in the
+// queue that matches filter (or all jobs, if filter is nil), in pickup
+// order. Nothing is claimed.
+func (dq *DirQueue) ListJobs(filter JobFilter) ([]JobInfo, error) {
+	var jobs []JobInfo
+	err := dq.scanJobs(func(info *JobInfo) error {
+		if filter == nil || filter(info) {
+			jobs = append(jobs, *info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// OldestPendingJob returns the JobInfo of the pending job that has
+// been waiting longest (regardless of priority), and its age. If
+// there are no pending jobs, ErrNoJobs is returned.
+func (dq *DirQueue) OldestPendingJob() (*JobInfo, time.Duration, error) {
+	var oldest *JobInfo
+	err := dq.scanJobs(func(info *JobInfo) error {
+		if info.State == StatePending &&
+			(oldest == nil || info.EnqueueTime.Before(oldest.EnqueueTime)) {
+			oldest = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if oldest == nil {
+		return nil, 0, ErrNoJobs
+	}
+	return oldest, oldest.Age(), nil
+}
@@ -0,0 +1,228 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeek(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	_, err = dq.Peek("")
+	assert.Equal(t, ErrNoJobs, err, "Peek on empty queue")
+
+	opts := DefaultOptions()
+	opts.Metadata["foo"] = "abc"
+	err = dq.EnqueueString("low priority", opts)
+	assert.Nil(t, err, "EnqueueString")
+	opts = DefaultOptions()
+	opts.Priority = 10
+	err = dq.EnqueueString("high priority", opts)
+	assert.Nil(t, err, "EnqueueString")
+
+	// Head of queue is the lowest priority number
+	info, err := dq.Peek("")
+	assert.Nil(t, err, "Peek head")
+	if assert.NotNil(t, info, "Peek head info") {
+		assert.Equal(t, uint8(10), info.Priority, "head priority")
+		assert.Equal(t, int64(13), info.Size, "head size")
+		assert.Equal(t, 0, len(info.Metadata), "head metadata")
+	}
+
+	cf, err := filepath.Glob(filepath.Join(testq, "queue", "50.*"))
+	assert.Nil(t, err, "control file Glob")
+	if assert.Equal(t, 1, len(cf), "one priority 50 control file found") {
+		id := filepath.Base(cf[0])
+		info, err = dq.Peek(id)
+		assert.Nil(t, err, "Peek by id")
+		assert.Equal(t, id, info.ID, "peeked id")
+		assert.Equal(t, uint8(50), info.Priority, "peeked priority")
+		assert.Equal(t, map[string]string{"foo": "abc"}, info.Metadata, "peeked metadata")
+		assert.False(t, info.EnqueueTime.IsZero(), "peeked enqueue time")
+		assert.True(t, info.Hostname != "", "peeked hostname")
+	}
+
+	_, err = dq.Peek("../data")
+	assert.NotNil(t, err, "Peek with path id")
+}
+
+func TestListJobs(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs on empty queue")
+	assert.Equal(t, 0, len(jobs), "no jobs")
+
+	for _, pri := range []uint8{50, 20, 70} {
+		opts := DefaultOptions()
+		opts.Priority = pri
+		opts.Metadata["pri"] = fmt.Sprintf("%d", pri)
+		err = dq.EnqueueString("data", opts)
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	// Mark the priority 70 job as active
+	cf, err := filepath.Glob(filepath.Join(testq, "queue", "70.*"))
+	assert.Nil(t, err, "control file Glob")
+	if assert.Equal(t, 1, len(cf), "one priority 70 control file found") {
+		err = ioutil.WriteFile(filepath.Join(dq.ActiveDir, filepath.Base(cf[0])), nil, 0644)
+		assert.Nil(t, err, "active lock write")
+	}
+
+	jobs, err = dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 3, len(jobs), "all jobs") {
+		assert.Equal(t, uint8(20), jobs[0].Priority, "first job priority")
+		assert.Equal(t, StatePending, jobs[0].State, "first job state")
+		assert.Equal(t, "20", jobs[0].Metadata["pri"], "first job metadata")
+		assert.Equal(t, uint8(70), jobs[2].Priority, "last job priority")
+		assert.Equal(t, StateActive, jobs[2].State, "last job state")
+		assert.True(t, jobs[0].Age() >= 0, "first job age")
+	}
+
+	jobs, err = dq.ListJobs(func(info *JobInfo) bool {
+		return info.State == StatePending && info.Priority >= 50
+	})
+	assert.Nil(t, err, "ListJobs filtered")
+	if assert.Equal(t, 1, len(jobs), "filtered jobs") {
+		assert.Equal(t, uint8(50), jobs[0].Priority, "filtered job priority")
+	}
+}
+
+func TestOldestPendingJob(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	_, _, err = dq.OldestPendingJob()
+	assert.Equal(t, ErrNoJobs, err, "OldestPendingJob on empty queue")
+
+	// The oldest job has the lowest priority, so isn't the queue head
+	for _, pri := range []uint8{90, 10} {
+		opts := DefaultOptions()
+		opts.Priority = pri
+		err = dq.EnqueueString("data", opts)
+		assert.Nil(t, err, "EnqueueString")
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	info, age, err := dq.OldestPendingJob()
+	assert.Nil(t, err, "OldestPendingJob")
+	if assert.NotNil(t, info, "OldestPendingJob info") {
+		assert.Equal(t, uint8(90), info.Priority, "oldest job priority")
+	}
+	assert.True(t, age > 0, "oldest job age")
+}
+
+func TestWalk(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	for i := 0; i < 3; i++ {
+		err = dq.EnqueueString("walk", &Options{Priority: uint8(10 + i)})
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	var seen []uint8
+	err = dq.Walk(func(info *JobInfo) error {
+		seen = append(seen, info.Priority)
+		return nil
+	})
+	assert.Nil(t, err, "Walk")
+	assert.Equal(t, []uint8{10, 11, 12}, seen, "all jobs in order")
+
+	// SkipRest stops cleanly
+	seen = nil
+	err = dq.Walk(func(info *JobInfo) error {
+		seen = append(seen, info.Priority)
+		return SkipRest
+	})
+	assert.Nil(t, err, "Walk SkipRest")
+	assert.Equal(t, []uint8{10}, seen, "stopped after first job")
+
+	// Other errors abort, wrapped
+	errBoom := errors.New("boom")
+	var id string
+	err = dq.Walk(func(info *JobInfo) error {
+		id = info.ID
+		return errBoom
+	})
+	assert.True(t, errors.Is(err, errBoom), "Walk error wrapped")
+	assert.Contains(t, err.Error(), id, "Walk error includes job id")
+
+	// Jobs removed during the walk are skipped
+	seen = nil
+	err = dq.Walk(func(info *JobInfo) error {
+		seen = append(seen, info.Priority)
+		if len(seen) == 1 {
+			jobs, err := dq.ListJobs(nil)
+			assert.Nil(t, err, "ListJobs")
+			return os.Remove(filepath.Join(dq.QueueDir, jobs[1].ID))
+		}
+		return nil
+	})
+	assert.Nil(t, err, "Walk with removal")
+	assert.Equal(t, []uint8{10, 12}, seen, "removed job skipped")
+}
+
+func TestJobInfoOptions(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	opts := &Options{Priority: 30, Metadata: map[string]string{"attempt": "1"}}
+	err = dq.EnqueueString("first", opts)
+	assert.Nil(t, err, "EnqueueString first")
+	time.Sleep(2 * time.Millisecond)
+	err = dq.EnqueueString("second", &Options{Priority: 30})
+	assert.Nil(t, err, "EnqueueString second")
+
+	first, err := dq.Peek("")
+	assert.Nil(t, err, "Peek")
+	opts = first.Options()
+	assert.Equal(t, uint8(30), opts.Priority, "inherited priority")
+	assert.Equal(t, first.EnqueueTime, opts.EnqueueTime, "inherited enqueue time")
+	assert.Equal(t, "1", opts.Metadata["attempt"], "inherited metadata")
+
+	// A follow-up keeps its predecessor's place ahead of later jobs
+	opts.Metadata["attempt"] = "2"
+	err = dq.EnqueueString("follow-up", opts)
+	assert.Nil(t, err, "EnqueueString follow-up")
+	jobs, err := dq.ListJobs(func(info *JobInfo) bool {
+		return info.Metadata["attempt"] == "2"
+	})
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 1, len(jobs), "follow-up listed") {
+		assert.Equal(t, first.EnqueueTime, jobs[0].EnqueueTime, "follow-up enqueue time")
+	}
+	all, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs all")
+	if assert.Equal(t, 3, len(all), "all jobs") {
+		assert.Equal(t, "", all[2].Metadata["attempt"], "later job last")
+	}
+}
@@ -0,0 +1,143 @@
+package dirqueue
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalFile is the name of the event journal in the queue root
+const journalFile = "journal"
+
+// EventType identifies the kind of a journal event
+type EventType string
+
+const (
+	EventEnqueue EventType = "enqueue"
+	EventPickup  EventType = "pickup"
+	EventFinish  EventType = "finish"
+	EventReturn  EventType = "return" // returned to the queue unfinished
+)
+
+// JournalEvent is an event read from a queue's journal
+type JournalEvent struct {
+	Time time.Time
+	Type EventType
+	ID   string // job id
+}
+
+// journal appends an event for job id to the queue's journal, if
+// enabled. Each event is a single short O_APPEND write, so events from
+// concurrent processes don't interleave. Failures are only warned
+// about.
+func (dq *DirQueue) journal(typ EventType, id string) {
+	if !dq.Journal {
+		return
+	}
+	path := filepath.Join(dq.RootDir, journalFile)
+	line := fmt.Sprintf("%s %s %s\n", dq.now().UTC().Format(time.RFC3339Nano), typ, id)
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err == nil {
+		_, err = fh.Write([]byte(line))
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal write to %q failed: %s\n", path, err.Error())
+	}
+}
+
+// JournalReader reads the events in a queue's journal, tracking its
+// position in a cursor file, so that an external system can tail the
+// journal across restarts. Events are delivered at least once: those
+// read since the last Commit are read again after a restart.
+type JournalReader struct {
+	Queue      *DirQueue
+	CursorPath string
+
+	offset int64 // journal offset after the last Read
+	loaded bool
+}
+
+// NewJournalReader returns a reference to a JournalReader struct for
+// the journal of dq, with its cursor stored in cursorPath
+func NewJournalReader(dq *DirQueue, cursorPath string) *JournalReader {
+	return &JournalReader{Queue: dq, CursorPath: cursorPath}
+}
+
+// Read returns the events appended to the journal since the last Read
+// (or since the committed cursor, on the first Read). If the journal
+// has been truncated or replaced, reading restarts from its beginning.
+func (jr *JournalReader) Read() ([]JournalEvent, error) {
+	if !jr.loaded {
+		data, err := ioutil.ReadFile(jr.CursorPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			jr.offset, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid journal cursor %q", jr.CursorPath)
+			}
+		}
+		jr.loaded = true
+	}
+
+	fh, err := os.Open(filepath.Join(jr.Queue.RootDir, journalFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	stat, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < jr.offset {
+		jr.offset = 0
+	}
+	_, err = fh.Seek(jr.offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only take complete lines, leaving any partial write for next time
+	end := bytes.LastIndexByte(data, '\n') + 1
+	var events []JournalEvent
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+		events = append(events, JournalEvent{Time: ts, Type: EventType(fields[1]), ID: fields[2]})
+	}
+	jr.offset += int64(end)
+	return events, nil
+}
+
+// Commit saves the reader's position after the last Read to its cursor
+// file, so those events aren't read again after a restart
+func (jr *JournalReader) Commit() error {
+	tmp := jr.CursorPath + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(jr.offset, 10)+"\n"), 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, jr.CursorPath)
+}
@@ -0,0 +1,85 @@
+package dirqueue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	cursor := filepath.Join(t.TempDir(), "cursor")
+	jr := NewJournalReader(dq, cursor)
+
+	// Nothing journalled unless enabled
+	err = dq.EnqueueString("unjournalled", nil)
+	assert.Nil(t, err, "EnqueueString")
+	events, err := jr.Read()
+	assert.Nil(t, err, "Read")
+	assert.Equal(t, 0, len(events), "no journal")
+
+	dq.Journal = true
+	for i := 0; i < 2; i++ {
+		err = dq.EnqueueString("journalled", nil)
+		assert.Nil(t, err, "EnqueueString")
+	}
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read")
+	if assert.Equal(t, 2, len(events), "two events") {
+		assert.Equal(t, EventEnqueue, events[0].Type, "event type")
+		assert.Equal(t, jobs[1].ID, events[0].ID, "first event id")
+		assert.Equal(t, jobs[2].ID, events[1].ID, "second event id")
+		assert.False(t, events[0].Time.IsZero(), "event time")
+	}
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read again")
+	assert.Equal(t, 0, len(events), "no new events")
+
+	// Uncommitted events are read again by a new reader
+	events, err = NewJournalReader(dq, cursor).Read()
+	assert.Nil(t, err, "Read new reader")
+	assert.Equal(t, 2, len(events), "events redelivered")
+
+	err = jr.Commit()
+	assert.Nil(t, err, "Commit")
+	err = dq.EnqueueString("journalled", nil)
+	assert.Nil(t, err, "EnqueueString")
+	jr = NewJournalReader(dq, cursor)
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read after Commit")
+	assert.Equal(t, 1, len(events), "only new event")
+
+	// Partial lines are left for the next Read
+	path := filepath.Join(dq.RootDir, journalFile)
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err, "open journal")
+	_, err = fh.WriteString("2021-06-07T09:30:00Z enqueue 50.partial")
+	assert.Nil(t, err, "partial write")
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read partial")
+	assert.Equal(t, 0, len(events), "partial line skipped")
+	_, err = fh.WriteString("\n")
+	assert.Nil(t, err, "complete write")
+	fh.Close()
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read completed")
+	if assert.Equal(t, 1, len(events), "completed line read") {
+		assert.Equal(t, "50.partial", events[0].ID, "completed event id")
+	}
+
+	// A replaced journal is read from the start
+	err = ioutil.WriteFile(path, []byte("2021-06-07T09:30:00Z enqueue 50.new\n"), 0644)
+	assert.Nil(t, err, "replace journal")
+	events, err = jr.Read()
+	assert.Nil(t, err, "Read replaced")
+	if assert.Equal(t, 1, len(events), "replaced journal read") {
+		assert.Equal(t, "50.new", events[0].ID, "replaced event id")
+	}
+}
@@ -0,0 +1,45 @@
+package dirqueue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// ContentTypeKey is the metadata key recording a job payload's MIME
+// type
+const ContentTypeKey = "content-type"
+
+const jsonContentType = "application/json"
+
+// EnqueueJSON enqueues the JSON encoding of v into the current queue
+// (with options in opts, if set), with a content-type metadatum of
+// "application/json"
+func (dq *DirQueue) EnqueueJSON(v interface{}, opts *Options) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	opts = dq.withDefaults(opts).withMetadatum(ContentTypeKey, jsonContentType)
+	return dq.EnqueueReader(bytes.NewReader(data), opts)
+}
+
+// DecodeJSON decodes the JSON payload of the job info into v. An error
+// is returned if the job's content-type isn't "application/json".
+func (dq *DirQueue) DecodeJSON(info *JobInfo, v interface{}) error {
+	if ct := info.Metadata[ContentTypeKey]; ct != jsonContentType {
+		return fmt.Errorf("job %s has content-type %q, not %q",
+			info.ID, ct, jsonContentType)
+	}
+	rdr, err := dq.OpenPayload(info)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
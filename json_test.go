@@ -0,0 +1,46 @@
+package dirqueue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnqueueJSON(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Transforms = []Transform{GzipTransform{}}
+
+	type task struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}
+	in := task{Name: "resize", Count: 3, Tags: []string{"a", "b"}}
+	opts := DefaultOptions()
+	opts.Metadata["foo"] = "bar"
+	err = dq.EnqueueJSON(in, opts)
+	assert.Nil(t, err, "EnqueueJSON")
+	_, ok := opts.Metadata[ContentTypeKey]
+	assert.False(t, ok, "opts unchanged")
+
+	info, err := dq.Peek("")
+	assert.Nil(t, err, "Peek")
+	assert.Equal(t, "application/json", info.Metadata["content-type"], "content-type")
+	assert.Equal(t, "bar", info.Metadata["foo"], "metadata kept")
+
+	var out task
+	err = dq.DecodeJSON(info, &out)
+	assert.Nil(t, err, "DecodeJSON")
+	assert.Equal(t, in, out, "round trip")
+
+	err = dq.EnqueueJSON(make(chan int), nil)
+	assert.NotNil(t, err, "EnqueueJSON unmarshalable")
+
+	err = dq.EnqueueString("not json", nil)
+	assert.Nil(t, err, "EnqueueString")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	err = dq.DecodeJSON(&jobs[1], &out)
+	assert.NotNil(t, err, "DecodeJSON wrong content-type")
+}
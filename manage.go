@@ -0,0 +1,118 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrJobNotPending is returned when a job to be cancelled or
+// reprioritized is locked by a consumer or no longer queued
+var ErrJobNotPending = errors.New("job not pending")
+
+// MoveJob moves the pending job info to the queue dst, keeping its
+// priority, enqueue time and metadata. The job is claimed first, so
+// ErrJobNotPending is returned if a consumer has already picked it up.
+func (dq *DirQueue) MoveJob(info *JobInfo, dst *DirQueue) error {
+	claimed, err := dq.claimJob(info.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrJobNotPending
+	}
+	err = dst.enqueueJobInfo(*info)
+	if err != nil && !errors.Is(err, ErrMirrorFailed) {
+		dq.unclaimJob(info.ID)
+		return err
+	}
+	dq.removeJob(*info)
+	return err
+}
+
+// ErrJobNotActive is returned when a job to be requeued isn't locked
+// by a consumer
+var ErrJobNotActive = errors.New("job not active")
+
+// CancelJob removes the pending job info from the queue. The job is
+// claimed first, so ErrJobNotPending is returned if a consumer has
+// already picked it up.
+func (dq *DirQueue) CancelJob(info *JobInfo) error {
+	claimed, err := dq.claimJob(info.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrJobNotPending
+	}
+	dq.removeJob(*info)
+	return nil
+}
+
+// Reprioritize moves the pending job info to priority, keeping its
+// enqueue time, metadata and data file. Since the priority is encoded
+// in the control filename, the job gets a new ID, and info is updated
+// with it and the new priority. The job is claimed first, so
+// ErrJobNotPending is returned if a consumer has already picked it up.
+func (dq *DirQueue) Reprioritize(info *JobInfo, priority uint8) error {
+	if priority > 99 {
+		priority = 99
+	}
+	if _, ok := qfnamePriority(info.ID); !ok {
+		return fmt.Errorf("invalid job id %q", info.ID)
+	}
+	claimed, err := dq.claimJob(info.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrJobNotPending
+	}
+	defer dq.unclaimJob(info.ID)
+
+	// Link the control file in under the new name, and then unlink the
+	// old one, which stays locked meanwhile so isn't picked up twice
+	pathold := filepath.Join(dq.QueueDir, info.ID)
+	base := fmt.Sprintf("%02d%s", priority, info.ID[strings.Index(info.ID, "."):])
+	id := base
+	maxRetries := 10
+	for retry := 1; ; retry++ {
+		pathnew := filepath.Join(dq.QueueDir, id)
+		err = dq.fsop("link", pathnew, func() error {
+			return os.Link(pathold, pathnew)
+		})
+		if !os.IsExist(err) || retry == maxRetries {
+			break
+		}
+		// Another job has the same name at the new priority
+		id = base + fmt.Sprintf(".%d.%d", os.Getpid(), rand.Intn(65536))
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(pathold)
+	if err != nil {
+		_ = os.Remove(filepath.Join(dq.QueueDir, id))
+		return err
+	}
+
+	info.ID = id
+	info.Priority = priority
+	info.Band = dq.BandName(priority)
+	return nil
+}
+
+// RequeueJob returns the active job info to the pending jobs by
+// removing its consumer lock, e.g. after the consumer has died. The
+// job keeps its ID, priority and enqueue time. Any consumer still
+// working on the job isn't notified, so may finish it as well.
+func (dq *DirQueue) RequeueJob(info *JobInfo) error {
+	err := os.Remove(filepath.Join(dq.ActiveDir, info.ID))
+	if os.IsNotExist(err) {
+		return ErrJobNotActive
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package dirqueue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelReprioritize(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	for _, name := range []string{"a", "b", "c"} {
+		err = dq.EnqueueString(name, &Options{Priority: 50,
+			Metadata: map[string]string{"name": name}})
+		assert.Nil(t, err, "EnqueueString")
+	}
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	assert.Equal(t, 3, len(jobs), "jobs enqueued")
+
+	// Reprioritizing works on a full queue, and doesn't re-enqueue
+	dq.MaxJobs = 3
+	dq.Mirror, err = New(t.TempDir())
+	assert.Nil(t, err, "mirror constructor")
+	dq.Journal = true
+
+	err = dq.CancelJob(&jobs[0])
+	assert.Nil(t, err, "CancelJob")
+	err = dq.CancelJob(&jobs[0])
+	assert.Equal(t, ErrJobNotPending, err, "CancelJob again")
+
+	// Claimed jobs are left alone
+	claimed, err := dq.claimJob(jobs[1].ID)
+	assert.Nil(t, err, "claimJob")
+	assert.True(t, claimed, "claimed")
+	err = dq.Reprioritize(&jobs[1], 10)
+	assert.Equal(t, ErrJobNotPending, err, "Reprioritize active job")
+	err = dq.Reprioritize(&JobInfo{ID: "nodots"}, 10)
+	assert.NotNil(t, err, "Reprioritize malformed id")
+
+	oldID := jobs[2].ID
+	err = dq.Reprioritize(&jobs[2], 10)
+	assert.Nil(t, err, "Reprioritize")
+	assert.Equal(t, "10"+oldID[2:], jobs[2].ID, "info updated with new id")
+	assert.Equal(t, uint8(10), jobs[2].Priority, "info updated with new priority")
+	left, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs after")
+	if assert.Equal(t, 2, len(left), "jobs left") {
+		assert.Equal(t, jobs[2].ID, left[0].ID, "new id")
+		assert.Equal(t, uint8(10), left[0].Priority, "new priority")
+		assert.Equal(t, "c", left[0].Metadata["name"], "reprioritized job")
+		assert.Equal(t, jobs[2].EnqueueTime, left[0].EnqueueTime, "enqueue time kept")
+		assert.Equal(t, jobs[2].DataPath, left[0].DataPath, "data file kept")
+		assert.Equal(t, StateActive, left[1].State, "claimed job untouched")
+	}
+	active, err := dq.ActiveCount()
+	assert.Nil(t, err, "ActiveCount")
+	assert.Equal(t, 1, active, "old lock removed")
+	mirrored, err := dq.Mirror.PendingCount()
+	assert.Nil(t, err, "mirror PendingCount")
+	assert.Equal(t, 0, mirrored, "nothing mirrored")
+	_, err = os.Stat(filepath.Join(dq.RootDir, journalFile))
+	assert.True(t, os.IsNotExist(err), "nothing journalled")
+}
+
+func TestRequeueJob(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	err = dq.EnqueueString("data", nil)
+	assert.Nil(t, err, "EnqueueString")
+	info, err := dq.Peek("")
+	assert.Nil(t, err, "Peek")
+
+	err = dq.RequeueJob(info)
+	assert.Equal(t, ErrJobNotActive, err, "RequeueJob pending job")
+
+	claimed, err := dq.claimJob(info.ID)
+	assert.Nil(t, err, "claimJob")
+	assert.True(t, claimed, "claimed")
+	err = dq.RequeueJob(info)
+	assert.Nil(t, err, "RequeueJob")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 1, len(jobs), "job still queued") {
+		assert.Equal(t, info.ID, jobs[0].ID, "same job")
+		assert.Equal(t, StatePending, jobs[0].State, "pending again")
+	}
+}
+
+func TestMoveJob(t *testing.T) {
+	src, err := New(t.TempDir())
+	assert.Nil(t, err, "src constructor")
+	dst, err := New(t.TempDir())
+	assert.Nil(t, err, "dst constructor")
+	err = src.EnqueueString("move me", &Options{Priority: 20,
+		Metadata: map[string]string{"name": "moved"}})
+	assert.Nil(t, err, "EnqueueString")
+	info, err := src.Peek("")
+	assert.Nil(t, err, "Peek")
+
+	err = src.MoveJob(info, dst)
+	assert.Nil(t, err, "MoveJob")
+	err = src.MoveJob(info, dst)
+	assert.Equal(t, ErrJobNotPending, err, "MoveJob again")
+
+	_, err = src.Peek("")
+	assert.Equal(t, ErrNoJobs, err, "source empty")
+	moved, err := dst.Peek("")
+	assert.Nil(t, err, "dst Peek")
+	if assert.NotNil(t, moved, "moved job") {
+		assert.Equal(t, uint8(20), moved.Priority, "priority kept")
+		assert.Equal(t, info.EnqueueTime, moved.EnqueueTime, "enqueue time kept")
+		assert.Equal(t, "moved", moved.Metadata["name"], "metadata kept")
+	}
+}
@@ -0,0 +1,80 @@
+package dirqueue
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// mirrorBacklog is how many background mirror copies may be waiting
+// before enqueues block
+const mirrorBacklog = 100
+
+// mirrorCopy is a job waiting to be copied to the mirror, with its
+// data file already open, so the copy can still be read if a consumer
+// finishes the job first
+type mirrorCopy struct {
+	job Job
+	fh  *os.File
+}
+
+// mirrorQueue is a generation of the background mirror worker,
+// counting the copies sent to it in wg. Close retires a generation, so
+// a later MirrorAsync enqueue starts a new one, with its own wg.
+type mirrorQueue struct {
+	ch chan mirrorCopy
+	wg sync.WaitGroup
+}
+
+// mirrorAsync queues job to be copied to dq.Mirror by the background
+// worker, starting it if necessary. Failures are only warned about.
+func (dq *DirQueue) mirrorAsync(job Job) {
+	fh, err := os.Open(job.pathdata)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mirror enqueue to %q failed: %s\n",
+			dq.Mirror.RootDir, err.Error())
+		return
+	}
+
+	dq.mirrorMu.Lock()
+	if dq.mirrorQ == nil {
+		dq.mirrorQ = &mirrorQueue{ch: make(chan mirrorCopy, mirrorBacklog)}
+		go dq.mirrorWorker(dq.mirrorQ)
+	}
+	mq := dq.mirrorQ
+	// Added under mirrorMu, so never concurrently with Close's Wait
+	mq.wg.Add(1)
+	dq.mirrorMu.Unlock()
+	mq.ch <- mirrorCopy{job: job, fh: fh}
+}
+
+// mirrorWorker copies the jobs sent to mq to dq.Mirror, until its
+// channel is closed
+func (dq *DirQueue) mirrorWorker(mq *mirrorQueue) {
+	for c := range mq.ch {
+		err := copyPayload(c.fh, c.job, dq.Mirror)
+		c.fh.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mirror enqueue to %q failed: %s\n",
+				dq.Mirror.RootDir, err.Error())
+		}
+		mq.wg.Done()
+	}
+}
+
+// Close waits for any background mirror copies (see MirrorAsync) to
+// finish, and stops the mirror worker. It should be called before
+// exiting, or pending copies are lost. The DirQueue may still be used
+// afterwards.
+func (dq *DirQueue) Close() error {
+	dq.mirrorMu.Lock()
+	mq := dq.mirrorQ
+	dq.mirrorQ = nil
+	dq.mirrorMu.Unlock()
+	if mq == nil {
+		return nil
+	}
+	mq.wg.Wait()
+	close(mq.ch)
+	return nil
+}
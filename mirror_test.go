@@ -0,0 +1,135 @@
+package dirqueue
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirror(t *testing.T) {
+	testq := "testqueue"
+	mirrorq := filepath.Join(testq, "mirror")
+
+	nukeQueue(t, testq)
+	nukeTree(t, mirrorq)
+	defer nukeTree(t, mirrorq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	dq.Mirror, err = New(mirrorq)
+	assert.Nil(t, err, "mirror constructor")
+
+	opts := DefaultOptions()
+	opts.Priority = 20
+	opts.Metadata["foo"] = "bar"
+	err = dq.EnqueueString("mirrored", opts)
+	assert.Nil(t, err, "EnqueueString")
+
+	info, err := dq.Mirror.Peek("")
+	if assert.Nil(t, err, "mirror Peek") {
+		assert.Equal(t, uint8(20), info.Priority, "mirrored priority")
+		assert.Equal(t, int64(8), info.Size, "mirrored size")
+		assert.Equal(t, map[string]string{"foo": "bar"}, info.Metadata, "mirrored metadata")
+	}
+
+	// Asynchronous mirroring, copying jobs even if they're finished
+	// before the worker gets to them
+	dq.MirrorAsync = true
+	err = dq.EnqueueString("mirrored later", nil)
+	assert.Nil(t, err, "EnqueueString async")
+	for {
+		job, err := dq.PickupQueuedJob()
+		if err == ErrNoJobs {
+			break
+		}
+		assert.Nil(t, err, "PickupQueuedJob")
+		assert.Nil(t, job.Finish(), "Finish")
+	}
+	err = dq.Close()
+	assert.Nil(t, err, "Close")
+	pending, err := dq.Mirror.PendingCount()
+	assert.Nil(t, err, "mirror PendingCount")
+	assert.Equal(t, 2, pending, "async mirrored job")
+	jobs, err := dq.Mirror.ListJobs(nil)
+	assert.Nil(t, err, "mirror ListJobs")
+	if assert.Equal(t, 2, len(jobs), "mirror jobs") {
+		assert.Equal(t, int64(14), jobs[1].Size, "async mirrored size")
+	}
+
+	// A synchronous mirror failure is reported, but the job is kept
+	dq.MirrorAsync = false
+	dq.Mirror.MaxJobs = 2
+	err = dq.EnqueueString("not mirrored", nil)
+	assert.True(t, errors.Is(err, ErrMirrorFailed), "ErrMirrorFailed")
+	pending, err = dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 1, pending, "job enqueued despite mirror failure")
+}
+
+func TestMirrorCloseConcurrent(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Mirror, err = New(t.TempDir())
+	assert.Nil(t, err, "mirror constructor")
+	dq.MirrorAsync = true
+
+	// Closing while other goroutines enqueue must not lose copies
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, dq.EnqueueString("mirrored", nil), "EnqueueString")
+		}()
+		assert.Nil(t, dq.Close(), "Close")
+	}
+	wg.Wait()
+	assert.Nil(t, dq.Close(), "final Close")
+	pending, err := dq.Mirror.PendingCount()
+	assert.Nil(t, err, "mirror PendingCount")
+	assert.Equal(t, n, pending, "all jobs mirrored")
+}
+
+func TestShadow(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Shadow, err = New(t.TempDir())
+	assert.Nil(t, err, "shadow constructor")
+
+	// Nothing sampled at 0%, everything at 100%
+	opts := DefaultOptions()
+	opts.Metadata["foo"] = "bar"
+	err = dq.EnqueueString("unsampled", opts)
+	assert.Nil(t, err, "EnqueueString")
+	dq.ShadowPercent = 100
+	err = dq.EnqueueString("sampled", opts)
+	assert.Nil(t, err, "EnqueueString")
+	assert.Equal(t, map[string]string{"foo": "bar"}, opts.Metadata, "opts unchanged")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 2, len(jobs), "two jobs") {
+		assert.Equal(t, "false", jobs[0].Metadata["shadow_sampled"], "first not sampled")
+		assert.Equal(t, "true", jobs[1].Metadata["shadow_sampled"], "second sampled")
+	}
+	shadowJobs, err := dq.Shadow.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs shadow")
+	if assert.Equal(t, 1, len(shadowJobs), "one shadow job") {
+		assert.Equal(t, "bar", shadowJobs[0].Metadata["foo"], "shadow metadata")
+		assert.Equal(t, jobs[1].Size, shadowJobs[0].Size, "shadow size")
+	}
+
+	// Roughly the right share is sampled
+	dq.ShadowPercent = 50
+	for i := 0; i < 200; i++ {
+		err = dq.EnqueueString("maybe", nil)
+		assert.Nil(t, err, "EnqueueString")
+	}
+	count, err := dq.Shadow.PendingCount()
+	assert.Nil(t, err, "PendingCount shadow")
+	assert.True(t, count > 50 && count < 150, "about half sampled")
+}
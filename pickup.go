@@ -0,0 +1,182 @@
+package dirqueue
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// QueuedJob is a job claimed by a consumer with PickupQueuedJob. Once
+// processed it should be removed with Finish, or returned to the queue
+// with ReturnToQueue; until then it stays locked in the active
+// directory.
+// This is the equivalent of the perl IPC::DirQueue::Job.
+type QueuedJob struct {
+	JobInfo
+	dq *DirQueue
+}
+
+// PickupQueuedJob claims the job at the head of the queue, returning
+// ErrNoJobs if there are no pending jobs. Jobs are claimed by hard
+// linking a lock file into the active directory, so each is picked up
+// by only one consumer, even across hosts sharing the queue.
+// This is the equivalent of the perl IPC::DirQueue::pickup_queued_job().
+func (dq *DirQueue) PickupQueuedJob() (*QueuedJob, error) {
+	err := dq.createLazy()
+	if err != nil {
+		return nil, err
+	}
+	active, err := dq.entries(dq.ActiveDir)
+	if err != nil {
+		return nil, err
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, name := range active {
+		isActive[name] = true
+	}
+	queued, err := dq.entries(dq.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(queued)
+
+	for _, qfname := range queued {
+		if isActive[qfname] {
+			continue
+		}
+		claimed, err := dq.claimJob(qfname)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			// Another consumer got there first
+			continue
+		}
+
+		ctrl, err := readControlFile(filepath.Join(dq.QueueDir, qfname))
+		if err != nil {
+			dq.unclaimJob(qfname)
+			if errors.Is(err, ErrBadControlFile) {
+				dq.quarantine(qfname)
+				continue
+			}
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		job := &QueuedJob{JobInfo: *dq.newJobInfo(qfname, StateActive, ctrl), dq: dq}
+		dq.Statsd.count("pickup", 1)
+		dq.journal(EventPickup, qfname)
+		return job, nil
+	}
+	return nil, ErrNoJobs
+}
+
+// defaultPollInterval is how often WaitForQueuedJob checks for jobs
+// by default
+const defaultPollInterval = time.Second
+
+// WaitForQueuedJob claims the job at the head of the queue, waiting
+// for one to be enqueued if necessary, checking every pollInterval
+// (default 1s). If no job arrives within timeout, ErrNoJobs is
+// returned; a zero timeout waits forever.
+// This is the equivalent of the perl IPC::DirQueue::wait_for_queued_job().
+func (dq *DirQueue) WaitForQueuedJob(timeout, pollInterval time.Duration) (*QueuedJob, error) {
+	return waitForJob(dq.PickupQueuedJob, timeout, pollInterval)
+}
+
+// waitForJob calls pickup until it returns something other than
+// ErrNoJobs, as for WaitForQueuedJob
+func waitForJob(pickup func() (*QueuedJob, error), timeout, pollInterval time.Duration) (*QueuedJob, error) {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		job, err := pickup()
+		if err != ErrNoJobs {
+			return job, err
+		}
+		wait := pollInterval
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return nil, ErrNoJobs
+			}
+			if left < wait {
+				wait = left
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Open returns a reader for the job's payload, with any transforms
+// reversed, which the caller must close
+func (j *QueuedJob) Open() (io.ReadCloser, error) {
+	return j.dq.OpenPayload(&j.JobInfo)
+}
+
+// Data returns the job's payload, with any transforms reversed.
+// This is the equivalent of the perl IPC::DirQueue::Job::get_data().
+func (j *QueuedJob) Data() ([]byte, error) {
+	rdr, err := j.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return ioutil.ReadAll(rdr)
+}
+
+// DecodeJSON decodes the job's JSON payload into v, as for
+// DirQueue.DecodeJSON
+func (j *QueuedJob) DecodeJSON(v interface{}) error {
+	return j.dq.DecodeJSON(&j.JobInfo, v)
+}
+
+// TouchActiveLock updates the modification time of the job's lock, to
+// show the consumer is still working on it.
+// This is the equivalent of the perl IPC::DirQueue::Job::touch_active_lock().
+func (j *QueuedJob) TouchActiveLock() error {
+	now := time.Now()
+	return os.Chtimes(filepath.Join(j.dq.ActiveDir, j.ID), now, now)
+}
+
+// Finish removes the completed job's data and control files, and then
+// its lock.
+// This is the equivalent of the perl IPC::DirQueue::Job::finish().
+func (j *QueuedJob) Finish() error {
+	for _, path := range []string{
+		j.DataPath,
+		filepath.Join(j.dq.QueueDir, j.ID),
+		filepath.Join(j.dq.ActiveDir, j.ID),
+	} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	j.dq.Statsd.count("finish", 1)
+	j.dq.journal(EventFinish, j.ID)
+	return nil
+}
+
+// ReturnToQueue unlocks the job, returning it to the pending jobs for
+// another consumer to pick up.
+// This is the equivalent of the perl IPC::DirQueue::Job::return_to_queue().
+func (j *QueuedJob) ReturnToQueue() error {
+	err := os.Remove(filepath.Join(j.dq.ActiveDir, j.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	j.dq.journal(EventReturn, j.ID)
+	return nil
+}
@@ -0,0 +1,163 @@
+package dirqueue
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickupQueuedJob(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Journal = true
+
+	_, err = dq.PickupQueuedJob()
+	assert.Equal(t, ErrNoJobs, err, "PickupQueuedJob on empty queue")
+
+	for _, pri := range []uint8{60, 40} {
+		err = dq.EnqueueString("payload", &Options{Priority: pri,
+			Metadata: map[string]string{"uuid": "u1"}})
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	// Jobs come out in priority order, locked
+	job, err := dq.PickupQueuedJob()
+	assert.Nil(t, err, "PickupQueuedJob")
+	if !assert.NotNil(t, job, "job") {
+		return
+	}
+	assert.Equal(t, uint8(40), job.Priority, "head job priority")
+	assert.Equal(t, StateActive, job.State, "job state")
+	assert.Equal(t, "u1", job.Metadata["uuid"], "job metadata")
+	data, err := job.Data()
+	assert.Nil(t, err, "Data")
+	assert.Equal(t, "payload", string(data), "job data")
+	active, err := dq.ActiveJobs()
+	assert.Nil(t, err, "ActiveJobs")
+	if assert.Equal(t, 1, len(active), "one active job") {
+		assert.Equal(t, job.ID, active[0].ID, "active job id")
+	}
+
+	old := time.Now().Add(-time.Hour)
+	lock := filepath.Join(dq.ActiveDir, job.ID)
+	assert.Nil(t, os.Chtimes(lock, old, old), "Chtimes")
+	assert.Nil(t, job.TouchActiveLock(), "TouchActiveLock")
+	stat, err := os.Stat(lock)
+	assert.Nil(t, err, "stat lock")
+	assert.True(t, stat.ModTime().After(old.Add(time.Minute)), "lock touched")
+
+	// A returned job is picked up again
+	next, err := dq.PickupQueuedJob()
+	assert.Nil(t, err, "PickupQueuedJob next")
+	assert.Nil(t, job.ReturnToQueue(), "ReturnToQueue")
+	again, err := dq.PickupQueuedJob()
+	assert.Nil(t, err, "PickupQueuedJob again")
+	assert.Equal(t, job.ID, again.ID, "returned job picked up")
+
+	for _, j := range []*QueuedJob{again, next} {
+		assert.Nil(t, j.Finish(), "Finish")
+		_, err = os.Stat(j.DataPath)
+		assert.True(t, os.IsNotExist(err), "data file removed")
+	}
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	assert.Equal(t, 0, len(jobs), "queue empty")
+
+	events, err := NewJournalReader(dq, filepath.Join(t.TempDir(), "cursor")).Read()
+	assert.Nil(t, err, "journal Read")
+	var types []EventType
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, []EventType{EventEnqueue, EventEnqueue, EventPickup, EventPickup,
+		EventReturn, EventPickup, EventFinish, EventFinish}, types, "journal events")
+}
+
+func TestPickupQueuedJobConcurrent(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	const njobs = 50
+	for i := 0; i < njobs; i++ {
+		err = dq.EnqueueString("data", nil)
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	// Each job is picked up exactly once
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := dq.PickupQueuedJob()
+				if err == ErrNoJobs {
+					return
+				}
+				if !assert.Nil(t, err, "PickupQueuedJob") {
+					return
+				}
+				mu.Lock()
+				seen[job.ID]++
+				mu.Unlock()
+				assert.Nil(t, job.Finish(), "Finish")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, njobs, len(seen), "all jobs picked up")
+	for id, n := range seen {
+		assert.Equal(t, 1, n, id+" picked up once")
+	}
+}
+
+func TestWaitForQueuedJob(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+
+	start := time.Now()
+	_, err = dq.WaitForQueuedJob(50*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, ErrNoJobs, err, "WaitForQueuedJob timeout")
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "waited for timeout")
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = dq.EnqueueString("late", nil)
+	}()
+	job, err := dq.WaitForQueuedJob(5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, err, "WaitForQueuedJob")
+	if assert.NotNil(t, job, "job") {
+		data, err := job.Data()
+		assert.Nil(t, err, "Data")
+		assert.Equal(t, "late", string(data), "job data")
+		assert.Nil(t, job.Finish(), "Finish")
+	}
+}
+
+func TestPickupOrderAcrossNoon(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	noon := time.Date(2021, 6, 7, 12, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{noon.Add(30 * time.Minute), noon.Add(90 * time.Minute)} {
+		err = dq.EnqueueString(ts.Format("15:04"), &Options{Priority: 50, EnqueueTime: ts})
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	// Jobs of the same priority come out in enqueue order, with the
+	// 13:30 job after the 12:30 one
+	for _, want := range []string{"12:30", "13:30"} {
+		job, err := dq.PickupQueuedJob()
+		if !assert.Nil(t, err, "PickupQueuedJob") {
+			return
+		}
+		data, err := job.Data()
+		assert.Nil(t, err, "Data")
+		assert.Equal(t, want, string(data), "pickup order")
+		assert.Nil(t, job.Finish(), "Finish")
+	}
+}
@@ -0,0 +1,76 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBadControlFile is returned for control files that are truncated
+// or can't be parsed
+var ErrBadControlFile = errors.New("malformed control file")
+
+// badCtrlSubdir is the queue subdirectory malformed control files
+// (and their data files) are moved to
+const badCtrlSubdir = "badctrl"
+
+// quarantine moves the malformed control file qfname from the queue
+// directory into the badctrl directory, along with its data file if
+// that can be found in the data directory. Failures are warned about,
+// but are otherwise non-fatal.
+func (dq *DirQueue) quarantine(qfname string) {
+	pathctrl := filepath.Join(dq.QueueDir, qfname)
+	pathbad, err := dqSubdir(dq.RootDir, badCtrlSubdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create %q: %s\n", badCtrlSubdir, err.Error())
+		return
+	}
+
+	// Find the data file from whatever QDFN line we can salvage
+	pathdata, _ := ctrlDataPath(pathctrl)
+
+	err = os.Rename(pathctrl, filepath.Join(pathbad, qfname))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to quarantine control file %q: %s\n",
+				pathctrl, err.Error())
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "quarantined malformed control file %q\n", pathctrl)
+
+	// Only move data files from our own data directory
+	if pathdata == "" {
+		return
+	}
+	datadir, err := filepath.Abs(dq.DataDir)
+	if err != nil || !strings.HasPrefix(pathdata, datadir+string(filepath.Separator)) {
+		return
+	}
+	err = os.Rename(pathdata, filepath.Join(pathbad, qfname+".data"))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "failed to quarantine data file %q: %s\n",
+			pathdata, err.Error())
+	}
+}
+
+// quarantinedCount returns the number of control files in the badctrl
+// directory
+func (dq *DirQueue) quarantinedCount() (int, error) {
+	names, err := dq.entries(filepath.Join(dq.RootDir, badCtrlSubdir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count := 0
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".data") {
+			count++
+		}
+	}
+	return count, nil
+}
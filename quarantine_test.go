@@ -0,0 +1,62 @@
+package dirqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	err = dq.EnqueueString("good", nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	// Truncate a second job's control file
+	err = dq.EnqueueString("bad", nil)
+	assert.Nil(t, err, "EnqueueString")
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 2, len(jobs), "two jobs") {
+		return
+	}
+	bad := jobs[1]
+	pathctrl := filepath.Join(dq.QueueDir, bad.ID)
+	data, err := ioutil.ReadFile(pathctrl)
+	assert.Nil(t, err, "control file read")
+	err = ioutil.WriteFile(pathctrl, data[:len(data)-5], 0644)
+	assert.Nil(t, err, "control file truncate")
+
+	_, err = dq.Peek(bad.ID)
+	assert.True(t, errors.Is(err, ErrBadControlFile), "Peek malformed job")
+
+	// Scanning quarantines the bad job and its data, keeping the good one
+	stats, err := dq.Stats()
+	assert.Nil(t, err, "Stats")
+	assert.Equal(t, 1, stats.Pending, "pending count")
+	assert.Equal(t, 1, stats.Quarantined, "quarantined count")
+
+	_, err = os.Stat(pathctrl)
+	assert.True(t, os.IsNotExist(err), "bad control file removed from queue")
+	_, err = os.Stat(filepath.Join(testq, "badctrl", bad.ID))
+	assert.Nil(t, err, "bad control file in badctrl")
+	_, err = os.Stat(bad.DataPath)
+	assert.True(t, os.IsNotExist(err), "bad data file removed from data dir")
+	_, err = os.Stat(filepath.Join(testq, "badctrl", bad.ID+".data"))
+	assert.Nil(t, err, "bad data file in badctrl")
+
+	// An empty metadata key would produce a malformed control file
+	opts := DefaultOptions()
+	opts.Metadata[""] = "empty"
+	err = dq.EnqueueString("data", opts)
+	assert.NotNil(t, err, "empty metadata key")
+}
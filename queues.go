@@ -0,0 +1,244 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned (wrapped in a QuotaError) when an
+// enqueue would take a queue or tenant over its job or byte limits
+var ErrQuotaExceeded = errors.New("queue quota exceeded")
+
+// QuotaError describes an enqueue rejected by a queue or tenant quota
+type QuotaError struct {
+	Tenant string // tenant name, or empty for a per-queue quota
+	Queue  string // root directory of the queue enqueued to
+	Limit  string // "jobs" or "bytes"
+	Used   int64  // usage before the rejected job
+	Max    int64
+}
+
+func (e *QuotaError) Error() string {
+	if e.Tenant != "" {
+		return fmt.Sprintf("%s: tenant %q has %d %s (limit %d)",
+			ErrQuotaExceeded, e.Tenant, e.Used, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s: %q has %d %s (limit %d)",
+		ErrQuotaExceeded, e.Queue, e.Used, e.Limit, e.Max)
+}
+
+// Unwrap returns ErrQuotaExceeded, so QuotaErrors match it with errors.Is
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Tenant groups a set of queues that share aggregate job and byte
+// limits. Queues are added to a tenant with Add.
+type Tenant struct {
+	Name     string
+	MaxJobs  int
+	MaxBytes int64
+
+	mu     sync.Mutex
+	queues []*DirQueue
+}
+
+// NewTenant returns a reference to a Tenant struct with the given
+// name and aggregate limits (zero meaning unlimited)
+func NewTenant(name string, maxJobs int, maxBytes int64) *Tenant {
+	return &Tenant{Name: name, MaxJobs: maxJobs, MaxBytes: maxBytes}
+}
+
+// Add adds dq to the tenant's queues, so that enqueues to dq are
+// checked against the tenant's limits
+func (t *Tenant) Add(dq *DirQueue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queues = append(t.queues, dq)
+	dq.Tenant = t
+}
+
+// Usage returns the total number of jobs and payload bytes across
+// the tenant's queues. Jobs are counted from directory entries, but
+// bytes are summed from every job's control file.
+func (t *Tenant) Usage() (int, int64, error) {
+	jobs, err := t.jobCount()
+	if err != nil {
+		return 0, 0, err
+	}
+	bytes, err := t.queuedBytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	return jobs, bytes, nil
+}
+
+// snapshot returns a copy of the tenant's queue list
+func (t *Tenant) snapshot() []*DirQueue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*DirQueue(nil), t.queues...)
+}
+
+// jobCount returns the number of jobs across the tenant's queues
+func (t *Tenant) jobCount() (int, error) {
+	total := 0
+	for _, dq := range t.snapshot() {
+		jobs, err := dq.jobCount()
+		if err != nil {
+			return 0, err
+		}
+		total += jobs
+	}
+	return total, nil
+}
+
+// queuedBytes returns the payload bytes across the tenant's queues
+func (t *Tenant) queuedBytes() (int64, error) {
+	var total int64
+	for _, dq := range t.snapshot() {
+		bytes, err := dq.queuedBytes()
+		if err != nil {
+			return 0, err
+		}
+		total += bytes
+	}
+	return total, nil
+}
+
+// ListQueues returns the names of the queues in parentDir, i.e. the
+// subdirectories of parentDir that contain a queue directory
+func ListQueues(parentDir string) ([]string, error) {
+	entries, err := os.ReadDir(parentDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		stat, err := os.Stat(filepath.Join(parentDir, e.Name(), "queue"))
+		if err == nil && stat.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// OpenNamed returns a reference to a DirQueue struct for the queue
+// called name in parentDir, creating it if necessary
+func OpenNamed(parentDir, name string) (*DirQueue, error) {
+	if name == "" || name == "." || name == ".." ||
+		strings.ContainsRune(name, filepath.Separator) {
+		return nil, fmt.Errorf("invalid queue name %q", name)
+	}
+	return New(filepath.Join(parentDir, name))
+}
+
+// quotaError returns a QuotaError if adding add to used would exceed
+// max (zero meaning unlimited) for limit ("jobs" or "bytes")
+func quotaError(tenant, queue, limit string, used, add, max int64) error {
+	if max > 0 && used+add > max {
+		return &QuotaError{Tenant: tenant, Queue: queue, Limit: limit,
+			Used: used, Max: max}
+	}
+	return nil
+}
+
+// jobCount returns the number of pending and active jobs in dq, read
+// from directory entries only
+func (dq *DirQueue) jobCount() (int, error) {
+	pending, err := dq.PendingCount()
+	if err != nil {
+		return 0, err
+	}
+	active, err := dq.ActiveCount()
+	if err != nil {
+		return 0, err
+	}
+	return pending + active, nil
+}
+
+// queuedBytes returns the payload bytes of the jobs in dq, as recorded
+// in their control files. Control files that vanish or are malformed
+// are skipped, and left for scans to quarantine.
+func (dq *DirQueue) queuedBytes() (int64, error) {
+	queued, err := dq.entries(dq.QueueDir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, qfname := range queued {
+		ctrl, err := readControlFile(filepath.Join(dq.QueueDir, qfname))
+		if err != nil {
+			if os.IsNotExist(err) || errors.Is(err, ErrBadControlFile) {
+				continue
+			}
+			return 0, err
+		}
+		size, _ := strconv.ParseInt(ctrl["QDSB"], 10, 64)
+		total += size
+	}
+	return total, nil
+}
+
+// checkJobQuota returns a QuotaError if adding n jobs would take dq
+// over its MaxJobs, or its tenant over the tenant MaxJobs. Only
+// directory entries are read, so it's cheap enough to run before any
+// payload is written. Concurrent enqueuers may overshoot the limits
+// slightly.
+func (dq *DirQueue) checkJobQuota(n int) error {
+	if dq.MaxJobs > 0 {
+		jobs, err := dq.jobCount()
+		if err != nil {
+			return err
+		}
+		err = quotaError("", dq.RootDir, "jobs", int64(jobs), int64(n), int64(dq.MaxJobs))
+		if err != nil {
+			return err
+		}
+	}
+
+	t := dq.Tenant
+	if t != nil && t.MaxJobs > 0 {
+		jobs, err := t.jobCount()
+		if err != nil {
+			return err
+		}
+		return quotaError(t.Name, dq.RootDir, "jobs", int64(jobs), int64(n), int64(t.MaxJobs))
+	}
+	return nil
+}
+
+// checkByteQuota returns a QuotaError if adding size payload bytes
+// would take dq over its MaxBytes, or its tenant over the tenant
+// MaxBytes. This reads every control file in the queues concerned, so
+// is only done if a byte limit is set.
+func (dq *DirQueue) checkByteQuota(size int64) error {
+	if dq.MaxBytes > 0 {
+		bytes, err := dq.queuedBytes()
+		if err != nil {
+			return err
+		}
+		err = quotaError("", dq.RootDir, "bytes", bytes, size, dq.MaxBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	t := dq.Tenant
+	if t != nil && t.MaxBytes > 0 {
+		bytes, err := t.queuedBytes()
+		if err != nil {
+			return err
+		}
+		return quotaError(t.Name, dq.RootDir, "bytes", bytes, size, t.MaxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package dirqueue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListQueues(t *testing.T) {
+	parent := filepath.Join("testqueue", "tenants")
+	nukeTree(t, parent)
+	defer nukeTree(t, parent)
+
+	_, err := OpenNamed(parent, "beta")
+	assert.Nil(t, err, "OpenNamed beta")
+	_, err = OpenNamed(parent, "alpha")
+	assert.Nil(t, err, "OpenNamed alpha")
+	err = os.MkdirAll(filepath.Join(parent, "notaqueue"), 0777)
+	assert.Nil(t, err, "mkdir notaqueue")
+
+	names, err := ListQueues(parent)
+	assert.Nil(t, err, "ListQueues")
+	assert.Equal(t, []string{"alpha", "beta"}, names, "queue names")
+
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		_, err = OpenNamed(parent, name)
+		assert.NotNil(t, err, "OpenNamed "+name)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	dq.MaxJobs = 2
+	dq.MaxBytes = 15
+
+	err = dq.EnqueueString("0123456789", nil)
+	assert.Nil(t, err, "first enqueue")
+	err = dq.EnqueueString("0123456789", nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "MaxBytes exceeded")
+	err = dq.EnqueueString("01234", nil)
+	assert.Nil(t, err, "second enqueue")
+	err = dq.EnqueueString("", nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "MaxJobs exceeded")
+
+	stats, err := dq.Stats()
+	assert.Nil(t, err, "Stats")
+	assert.Equal(t, 2, stats.Pending, "pending count")
+
+	// Rejected jobs should leave nothing behind
+	tf, err := filepath.Glob(filepath.Join(testq, "tmp", "*"))
+	assert.Nil(t, err, "tmp Glob")
+	assert.Equal(t, 0, len(tf), "no tmp files found")
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.n += len(p)
+	return len(p), nil
+}
+
+func TestQuotaBeforeWrite(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.MaxJobs = 1
+	err = dq.EnqueueString("data", nil)
+	assert.Nil(t, err, "first enqueue")
+
+	// An over-quota job count is rejected before the payload is read
+	rdr := &countingReader{}
+	err = dq.EnqueueReader(rdr, nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "MaxJobs exceeded")
+	assert.Equal(t, 0, rdr.n, "payload not read")
+
+	// Quota checks don't quarantine malformed control files
+	bad := filepath.Join(dq.QueueDir, "50.20210607093000000000.bad")
+	err = os.WriteFile(bad, []byte("QDFN"), 0644)
+	assert.Nil(t, err, "write bad control file")
+	dq.MaxJobs = 0
+	dq.MaxBytes = 1 << 20
+	err = dq.EnqueueString("data", nil)
+	assert.Nil(t, err, "enqueue under MaxBytes")
+	_, err = os.Stat(bad)
+	assert.Nil(t, err, "bad control file left in place")
+}
+
+func TestTenantQuota(t *testing.T) {
+	parent := filepath.Join("testqueue", "tenants")
+	nukeTree(t, parent)
+	defer nukeTree(t, parent)
+
+	tenant := NewTenant("acme", 0, 25)
+	dq1, err := OpenNamed(parent, "acme-in")
+	assert.Nil(t, err, "OpenNamed acme-in")
+	tenant.Add(dq1)
+	dq2, err := OpenNamed(parent, "acme-out")
+	assert.Nil(t, err, "OpenNamed acme-out")
+	tenant.Add(dq2)
+	other, err := OpenNamed(parent, "other")
+	assert.Nil(t, err, "OpenNamed other")
+
+	err = dq1.EnqueueString("0123456789", nil)
+	assert.Nil(t, err, "enqueue to acme-in")
+	err = dq2.EnqueueString("0123456789", nil)
+	assert.Nil(t, err, "enqueue to acme-out")
+
+	err = dq1.EnqueueString("0123456789", nil)
+	var qerr *QuotaError
+	if assert.True(t, errors.As(err, &qerr), "tenant quota error") {
+		assert.Equal(t, "acme", qerr.Tenant, "quota error tenant")
+		assert.Equal(t, "bytes", qerr.Limit, "quota error limit")
+		assert.Equal(t, int64(20), qerr.Used, "quota error usage")
+	}
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "ErrQuotaExceeded")
+
+	// Other tenants' queues are unaffected
+	err = other.EnqueueString("0123456789", nil)
+	assert.Nil(t, err, "enqueue to other")
+
+	jobs, bytes, err := tenant.Usage()
+	assert.Nil(t, err, "Usage")
+	assert.Equal(t, 2, jobs, "tenant jobs")
+	assert.Equal(t, int64(20), bytes, "tenant bytes")
+}
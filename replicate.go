@@ -0,0 +1,140 @@
+package dirqueue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Replicator copies new jobs from a source queue to a destination
+// queue (typically on a remote mount, feeding a standby consumer
+// site). Its cursor is the set of source job ids already copied,
+// persisted in CursorPath so that a restarted Replicator carries on
+// where it left off. Copies are at-least-once: a crash between copying
+// a job and saving the cursor copies it again on restart. Jobs consumed
+// from the source before they are replicated are not copied.
+type Replicator struct {
+	Source     *DirQueue
+	Dest       *DirQueue
+	CursorPath string
+	Interval   time.Duration // how often Run replicates (default 5s)
+}
+
+// NewReplicator returns a reference to a Replicator struct copying
+// jobs from src to dst, with its cursor stored in cursorPath
+func NewReplicator(src, dst *DirQueue, cursorPath string) *Replicator {
+	return &Replicator{Source: src, Dest: dst, CursorPath: cursorPath}
+}
+
+func (r *Replicator) readCursor() (map[string]bool, error) {
+	seen := make(map[string]bool)
+	data, err := ioutil.ReadFile(r.CursorPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return seen, nil
+		}
+		return nil, err
+	}
+	for _, id := range strings.Split(string(data), "\n") {
+		if id != "" {
+			seen[id] = true
+		}
+	}
+	return seen, nil
+}
+
+// writeCursor atomically replaces the cursor file with ids
+func (r *Replicator) writeCursor(ids []string) error {
+	sort.Strings(ids)
+	tmp := r.CursorPath + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(ids, "\n")+"\n"), 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.CursorPath)
+}
+
+// ReplicateOnce copies any source jobs not yet replicated to the
+// destination queue, returning the number copied
+func (r *Replicator) ReplicateOnce() (int, error) {
+	seen, err := r.readCursor()
+	if err != nil {
+		return 0, err
+	}
+	jobs, err := r.Source.ListJobs(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	present := make([]string, 0, len(jobs))
+	for _, info := range jobs {
+		present = append(present, info.ID)
+		if seen[info.ID] {
+			continue
+		}
+		err = r.Dest.enqueueJobInfo(info)
+		if os.IsNotExist(err) {
+			// Finished while we were scanning
+			continue
+		}
+		if err != nil {
+			// Record what we've copied so far before bailing out
+			ids := make([]string, 0, len(seen))
+			for id := range seen {
+				ids = append(ids, id)
+			}
+			_ = r.writeCursor(ids)
+			return copied, err
+		}
+		seen[info.ID] = true
+		copied++
+	}
+
+	// The new cursor only keeps ids still in the source queue, so it
+	// doesn't grow without bound
+	var ids []string
+	for _, id := range present {
+		if seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	return copied, r.writeCursor(ids)
+}
+
+// Run replicates every r.Interval until ctx is done (returning
+// ctx.Err()) or replication fails
+func (r *Replicator) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := r.ReplicateOnce()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueueJobInfo enqueues a copy of the job described by info (with
+// the same priority, enqueue time and metadata) into dq
+func (dq *DirQueue) enqueueJobInfo(info JobInfo) error {
+	fh, err := os.Open(info.DataPath)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return dq.EnqueueReader(fh, info.Options().storedAs(info.Metadata))
+}
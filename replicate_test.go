@@ -0,0 +1,62 @@
+package dirqueue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicator(t *testing.T) {
+	testq := "testqueue"
+	standbyq := filepath.Join(testq, "standby")
+	cursor := filepath.Join(testq, "replcursor")
+
+	nukeQueue(t, testq)
+	nukeTree(t, standbyq)
+	defer nukeTree(t, standbyq)
+	_ = os.Remove(cursor)
+	defer os.Remove(cursor)
+
+	src, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	dst, err := New(standbyq)
+	assert.Nil(t, err, "standby constructor")
+	r := NewReplicator(src, dst, cursor)
+
+	opts := DefaultOptions()
+	opts.Priority = 30
+	opts.Metadata["foo"] = "bar"
+	err = src.EnqueueString("first", opts)
+	assert.Nil(t, err, "EnqueueString")
+
+	n, err := r.ReplicateOnce()
+	assert.Nil(t, err, "ReplicateOnce")
+	assert.Equal(t, 1, n, "first job copied")
+	info, err := dst.Peek("")
+	if assert.Nil(t, err, "standby Peek") {
+		assert.Equal(t, uint8(30), info.Priority, "replicated priority")
+		assert.Equal(t, map[string]string{"foo": "bar"}, info.Metadata, "replicated metadata")
+	}
+
+	// A new Replicator picks up from the persisted cursor
+	r = NewReplicator(src, dst, cursor)
+	n, err = r.ReplicateOnce()
+	assert.Nil(t, err, "ReplicateOnce again")
+	assert.Equal(t, 0, n, "nothing new to copy")
+
+	err = src.EnqueueString("second", nil)
+	assert.Nil(t, err, "EnqueueString")
+	r.Interval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = r.Run(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "Run return")
+
+	pending, err := dst.PendingCount()
+	assert.Nil(t, err, "standby PendingCount")
+	assert.Equal(t, 2, pending, "both jobs replicated once")
+}
@@ -0,0 +1,345 @@
+package dirqueue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedSubdir is the queue subdirectory holding the per-tick markers
+// the Scheduler uses to avoid enqueueing the same tick twice
+const schedSubdir = "sched"
+
+// reSimpleName matches names safe to use in filenames and config
+// values, like schedule and priority band names
+var reSimpleName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSpec is a parsed five-field cron schedule, with each field held
+// as a bitset of the values it matches
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// parseCronField parses one cron field (e.g. "*", "1,15", "9-17",
+// "*/5") with values in the range min-max
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx > -1 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCron parses a standard five-field cron spec (minute, hour, day
+// of month, month, day of week) or one of the @hourly-style shortcuts
+func parseCron(spec string) (*cronSpec, error) {
+	if s, ok := cronShortcuts[spec]; ok {
+		spec = s
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron spec %q: want 5 fields", spec)
+	}
+
+	c := &cronSpec{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		*f.bits, err = parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron spec %q: %s", spec, err.Error())
+		}
+	}
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// matches reports whether the minute containing t is on the schedule
+func (c *cronSpec) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 ||
+		c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	// As in cron, if both day fields are restricted either may match
+	if !c.domStar && !c.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Schedule is a recurring job: Payload is enqueued (with Options, if
+// set) at every minute matching Spec, a five-field cron spec or one of
+// @hourly, @daily, @weekly, @monthly or @yearly
+type Schedule struct {
+	Name    string
+	Spec    string
+	Payload string
+	Options *Options
+
+	cron *cronSpec
+}
+
+// Scheduler enqueues recurring jobs into a queue. Each tick of a
+// schedule is only enqueued once, even by several Schedulers (e.g. on
+// different hosts) sharing the queue, using marker files in the
+// queue's sched directory. Ticks missed while no Scheduler is running
+// are not caught up.
+//
+// Scheduled jobs get "schedule" (the schedule name) and
+// "scheduled_at" (the tick time, in RFC 3339 format) metadata.
+// Schedules must all be added before Run is called.
+type Scheduler struct {
+	Location  *time.Location // time zone for schedules (default Local)
+	dq        *DirQueue
+	schedules []*Schedule
+}
+
+// NewScheduler returns a reference to a Scheduler struct enqueueing
+// into dq
+func NewScheduler(dq *DirQueue) *Scheduler {
+	return &Scheduler{Location: time.Local, dq: dq}
+}
+
+// Add adds schedule s to the scheduler
+func (sc *Scheduler) Add(s Schedule) error {
+	if !reSimpleName.MatchString(s.Name) {
+		return fmt.Errorf("invalid schedule name %q", s.Name)
+	}
+	for _, existing := range sc.schedules {
+		if existing.Name == s.Name {
+			return fmt.Errorf("duplicate schedule name %q", s.Name)
+		}
+	}
+	cron, err := parseCron(s.Spec)
+	if err != nil {
+		return err
+	}
+	s.cron = cron
+	sc.schedules = append(sc.schedules, &s)
+	return nil
+}
+
+// LoadFile adds the schedules defined in the crontab-style file path
+// (e.g. in the queue root). Each line holds a cron spec, a schedule
+// name and the rest of the line as the payload, e.g.
+//
+//	*/5 * * * * cleanup {"older_than": "1h"}
+//	@daily report
+//
+// Blank lines and lines starting with # are ignored.
+func (sc *Scheduler) LoadFile(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nspec := 5
+		if strings.HasPrefix(line, "@") {
+			nspec = 1
+		}
+		fields := strings.Fields(line)
+		if len(fields) < nspec+1 {
+			return fmt.Errorf("%s:%d: missing schedule name", path, lineno)
+		}
+		s := Schedule{
+			Spec: strings.Join(fields[:nspec], " "),
+			Name: fields[nspec],
+		}
+		// Payload is everything after the name, with spacing intact
+		rest := line
+		for _, f := range fields[:nspec+1] {
+			rest = strings.TrimLeft(rest, " \t")
+			rest = rest[len(f):]
+		}
+		s.Payload = strings.TrimLeft(rest, " \t")
+		err = sc.Add(s)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineno, err.Error())
+		}
+	}
+	return scanner.Err()
+}
+
+// markerPath returns the path of the marker for schedule name at tick
+func (sc *Scheduler) markerPath(name string, tick time.Time) string {
+	return filepath.Join(sc.dq.RootDir, schedSubdir, fmt.Sprintf("%s.%d", name, tick.Unix()))
+}
+
+// claimTick creates the marker for schedule name at tick, returning
+// false if another scheduler has already claimed it
+func (sc *Scheduler) claimTick(name string, tick time.Time) (bool, error) {
+	_, err := dqSubdir(sc.dq.RootDir, schedSubdir)
+	if err != nil {
+		return false, err
+	}
+	path := sc.markerPath(name, tick)
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, fh.Close()
+}
+
+// pruneMarkers removes tick markers more than a day older than now
+func (sc *Scheduler) pruneMarkers(now time.Time) {
+	dir := filepath.Join(sc.dq.RootDir, schedSubdir)
+	names, err := sc.dq.entries(dir)
+	if err != nil {
+		return
+	}
+	cutoff := now.Add(-24 * time.Hour).Unix()
+	for _, name := range names {
+		idx := strings.LastIndex(name, ".")
+		ts, err := strconv.ParseInt(name[idx+1:], 10, 64)
+		if err == nil && ts < cutoff {
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+// runTick enqueues the jobs for every schedule matching the minute
+// containing now, returning the first error. The markers of failed
+// ticks are removed, so they can be retried.
+func (sc *Scheduler) runTick(now time.Time) error {
+	tick := now.In(sc.Location).Truncate(time.Minute)
+	var firstErr error
+	for _, s := range sc.schedules {
+		if !s.cron.matches(tick) {
+			continue
+		}
+		ok, err := sc.claimTick(s.Name, tick)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("schedule %q: %w", s.Name, err)
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		opts := DefaultOptions()
+		if s.Options != nil {
+			copied := *s.Options
+			opts = &copied
+			opts.Metadata = make(map[string]string, len(s.Options.Metadata)+2)
+			for k, v := range s.Options.Metadata {
+				opts.Metadata[k] = v
+			}
+		}
+		opts.Metadata[scheduleKey] = s.Name
+		opts.Metadata[scheduledAtKey] = tick.Format(time.RFC3339)
+		err = sc.dq.EnqueueString(s.Payload, opts)
+		if err != nil {
+			_ = os.Remove(sc.markerPath(s.Name, tick))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("schedule %q: %w", s.Name, err)
+			}
+		}
+	}
+	sc.pruneMarkers(now)
+	return firstErr
+}
+
+// schedRetryDelay is how long Run waits before retrying failed ticks
+var schedRetryDelay = 5 * time.Second
+
+// Run enqueues scheduled jobs as their times arrive, until ctx is
+// done, returning ctx.Err(). Failed enqueues are warned about, and
+// retried every few seconds until the end of their minute.
+func (sc *Scheduler) Run(ctx context.Context) error {
+	for {
+		now := time.Now()
+		err := sc.runTick(now)
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "scheduler on %q: %s\n", sc.dq.RootDir, err.Error())
+			// Ticks already enqueued have markers, so only the failed
+			// ones are retried
+			if retry := time.Now().Add(schedRetryDelay); retry.Before(next) {
+				next = retry
+			}
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
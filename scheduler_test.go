@@ -0,0 +1,159 @@
+package dirqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron(t *testing.T) {
+	// 2021-06-07 was a Monday
+	mon0930 := time.Date(2021, 6, 7, 9, 30, 0, 0, time.UTC)
+	sun0000 := time.Date(2021, 6, 6, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec  string
+		t     time.Time
+		match bool
+	}{
+		{"* * * * *", mon0930, true},
+		{"*/15 * * * *", mon0930, true},
+		{"*/20 * * * *", mon0930, false},
+		{"30 9-17 * * 1-5", mon0930, true},
+		{"30 9-17 * * 1-5", sun0000.Add(9*time.Hour + 30*time.Minute), false},
+		{"0,30 9 7 6 *", mon0930, true},
+		{"0 0 * * 7", sun0000, true},
+		{"@daily", sun0000, true},
+		{"@hourly", mon0930, false},
+		// Restricted day-of-month and day-of-week match either
+		{"30 9 1 * 1", mon0930, true},
+		{"30 9 1 * 2", mon0930, false},
+	}
+	for _, tc := range tests {
+		c, err := parseCron(tc.spec)
+		if !assert.Nil(t, err, tc.spec) {
+			continue
+		}
+		assert.Equal(t, tc.match, c.matches(tc.t), tc.spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *",
+		"*/0 * * * *", "5-1 * * * *", "x * * * *", "@fortnightly"} {
+		_, err := parseCron(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	sc := NewScheduler(dq)
+	sc.Location = time.UTC
+	err = sc.Add(Schedule{Name: "quarterly", Spec: "*/15 * * * *", Payload: "q",
+		Options: &Options{Priority: 10, Metadata: map[string]string{"foo": "bar"}}})
+	assert.Nil(t, err, "Add")
+	err = sc.Add(Schedule{Name: "quarterly", Spec: "@daily"})
+	assert.NotNil(t, err, "Add duplicate name")
+	err = sc.Add(Schedule{Name: "bad/name", Spec: "@daily"})
+	assert.NotNil(t, err, "Add invalid name")
+
+	tick := time.Date(2021, 6, 7, 9, 30, 0, 0, time.UTC)
+	err = sc.runTick(tick.Add(10 * time.Second))
+	assert.Nil(t, err, "runTick")
+	// A second scheduler must not enqueue the same tick again
+	sc2 := NewScheduler(dq)
+	sc2.Location = time.UTC
+	err = sc2.Add(Schedule{Name: "quarterly", Spec: "*/15 * * * *", Payload: "q"})
+	assert.Nil(t, err, "Add")
+	err = sc2.runTick(tick.Add(40 * time.Second))
+	assert.Nil(t, err, "runTick second scheduler")
+	// Nor should a non-matching minute
+	err = sc.runTick(tick.Add(time.Minute))
+	assert.Nil(t, err, "runTick non-matching")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 1, len(jobs), "one job") {
+		return
+	}
+	assert.Equal(t, uint8(10), jobs[0].Priority, "priority")
+	assert.Equal(t, map[string]string{
+		"foo":          "bar",
+		"schedule":     "quarterly",
+		"scheduled_at": "2021-06-07T09:30:00Z",
+	}, jobs[0].Metadata, "metadata")
+
+	// Next matching tick is enqueued, and old markers are pruned
+	err = sc.runTick(tick.Add(48 * time.Hour))
+	assert.Nil(t, err, "runTick later")
+	markers, err := queueEntries(filepath.Join(testq, schedSubdir))
+	assert.Nil(t, err, "queueEntries")
+	assert.Equal(t, 1, len(markers), "markers pruned")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 2, count, "two jobs")
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.MaxJobs = 1
+	err = dq.EnqueueString("filler", nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	sc := NewScheduler(dq)
+	sc.Location = time.UTC
+	err = sc.Add(Schedule{Name: "minutely", Spec: "* * * * *", Payload: "m"})
+	assert.Nil(t, err, "Add")
+
+	// A failed enqueue must not leave its tick claimed
+	tick := time.Date(2021, 6, 7, 9, 30, 0, 0, time.UTC)
+	err = sc.runTick(tick)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "quota error returned")
+	_, err = os.Stat(sc.markerPath("minutely", tick))
+	assert.True(t, os.IsNotExist(err), "marker removed")
+
+	// So retrying the tick enqueues the job
+	dq.MaxJobs = 0
+	err = sc.runTick(tick.Add(5 * time.Second))
+	assert.Nil(t, err, "runTick retry")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 2, count, "scheduled job enqueued")
+}
+
+func TestSchedulerLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules")
+	err := ioutil.WriteFile(path, []byte(`# comment
+
+*/5 * * * *  cleanup  {"older_than": "1h"}
+@daily report
+`), 0644)
+	assert.Nil(t, err, "write schedules")
+
+	sc := NewScheduler(nil)
+	err = sc.LoadFile(path)
+	assert.Nil(t, err, "LoadFile")
+	if !assert.Equal(t, 2, len(sc.schedules), "two schedules") {
+		return
+	}
+	assert.Equal(t, "cleanup", sc.schedules[0].Name, "name")
+	assert.Equal(t, "*/5 * * * *", sc.schedules[0].Spec, "spec")
+	assert.Equal(t, `{"older_than": "1h"}`, sc.schedules[0].Payload, "payload")
+	assert.Equal(t, "report", sc.schedules[1].Name, "name")
+	assert.Equal(t, "", sc.schedules[1].Payload, "empty payload")
+
+	err = ioutil.WriteFile(path, []byte("* * * * *\n"), 0644)
+	assert.Nil(t, err, "write schedules")
+	err = NewScheduler(nil).LoadFile(path)
+	assert.NotNil(t, err, "LoadFile missing name")
+}
@@ -0,0 +1,87 @@
+package dirqueue
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// ErrInvalidMetadata is returned (wrapped in a SchemaError) when job
+// metadata doesn't conform to the queue's Schema
+var ErrInvalidMetadata = errors.New("invalid job metadata")
+
+// SchemaError describes a metadatum rejected by a Schema
+type SchemaError struct {
+	Key    string
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %q %s", ErrInvalidMetadata, e.Key, e.Reason)
+}
+
+// Unwrap returns ErrInvalidMetadata, so SchemaErrors match it with
+// errors.Is
+func (e *SchemaError) Unwrap() error {
+	return ErrInvalidMetadata
+}
+
+// Schema describes the metadata jobs in a queue may carry. Metadata
+// written by the library itself (shadow_sampled, transforms,
+// failover_origin, schedule, scheduled_at, content-type, traceparent
+// and tracestate) is always allowed.
+type Schema struct {
+	// Required keys must be present (and non-empty)
+	Required []string
+	// Allowed, if set, lists the only keys permitted besides Required
+	Allowed []string
+	// Patterns maps keys to regexes their values must match
+	Patterns map[string]*regexp.Regexp
+	// Enums maps keys to the only values they may take
+	Enums map[string][]string
+}
+
+// Check returns a SchemaError for the first metadatum in meta (in key
+// order) that doesn't conform to the schema, or nil. Consumers may use
+// it to verify jobs from producers not enforcing the schema.
+func (s *Schema) Check(meta map[string]string) error {
+	for _, k := range s.Required {
+		if meta[k] == "" {
+			return &SchemaError{Key: k, Reason: "is required"}
+		}
+	}
+
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if libraryKeys[k] {
+			continue
+		}
+		if s.Allowed != nil && !contains(s.Allowed, k) && !contains(s.Required, k) {
+			return &SchemaError{Key: k, Reason: "is not allowed"}
+		}
+		v := meta[k]
+		if re, ok := s.Patterns[k]; ok && !re.MatchString(v) {
+			return &SchemaError{Key: k,
+				Reason: fmt.Sprintf("value %q doesn't match %s", v, re)}
+		}
+		if enum, ok := s.Enums[k]; ok && !contains(enum, v) {
+			return &SchemaError{Key: k,
+				Reason: fmt.Sprintf("value %q not one of %q", v, enum)}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package dirqueue
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Transforms = []Transform{GzipTransform{}}
+	dq.Schema = &Schema{
+		Required: []string{"tenant"},
+		Allowed:  []string{"env", "bucket"},
+		Patterns: map[string]*regexp.Regexp{"tenant": regexp.MustCompile(`^t[0-9]+$`)},
+		Enums:    map[string][]string{"env": {"prod", "staging"}},
+	}
+
+	tests := []struct {
+		meta map[string]string
+		key  string
+	}{
+		{map[string]string{"env": "prod"}, "tenant"},
+		{map[string]string{"tenant": "acme"}, "tenant"},
+		{map[string]string{"tenant": "t1", "env": "dev"}, "env"},
+		{map[string]string{"tenant": "t1", "colour": "blue"}, "colour"},
+		{map[string]string{"tenant": "t1", "env": "prod", "bucket": "b"}, ""},
+	}
+	for _, tc := range tests {
+		err = dq.EnqueueString("data", &Options{Metadata: tc.meta})
+		if tc.key == "" {
+			assert.Nil(t, err, "conforming metadata")
+			continue
+		}
+		var serr *SchemaError
+		if assert.True(t, errors.As(err, &serr), "SchemaError for "+tc.key) {
+			assert.Equal(t, tc.key, serr.Key, "rejected key")
+		}
+		assert.True(t, errors.Is(err, ErrInvalidMetadata), "ErrInvalidMetadata")
+	}
+
+	// Queue-recorded metadata always passes
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if assert.Equal(t, 1, len(jobs), "one job enqueued") {
+		assert.Nil(t, dq.Schema.Check(jobs[0].Metadata), "Check enqueued job")
+	}
+}
+
+func TestSchemaLibraryKeys(t *testing.T) {
+	strict := func(dq *DirQueue) {
+		dq.Schema = &Schema{Allowed: []string{"tenant"}}
+	}
+
+	// EnqueueJSON's content-type
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	strict(dq)
+	err = dq.EnqueueJSON(map[string]int{"n": 1}, nil)
+	assert.Nil(t, err, "EnqueueJSON")
+
+	// SetTraceContext's traceparent and tracestate
+	opts := DefaultOptions()
+	err = opts.SetTraceContext("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "k=v")
+	assert.Nil(t, err, "SetTraceContext")
+	err = dq.EnqueueString("traced", opts)
+	assert.Nil(t, err, "EnqueueString traced")
+
+	// Scheduler's schedule and scheduled_at
+	sc := NewScheduler(dq)
+	sc.Location = time.UTC
+	err = sc.Add(Schedule{Name: "hourly", Spec: "@hourly", Payload: "tick"})
+	assert.Nil(t, err, "Add")
+	err = sc.runTick(time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC))
+	assert.Nil(t, err, "runTick")
+
+	// FailoverQueue's failover_origin
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "nfs")
+	err = ioutil.WriteFile(blocker, nil, 0644)
+	assert.Nil(t, err, "write blocker")
+	fq, err := NewFailoverQueue(filepath.Join(blocker, "q"), filepath.Join(dir, "local"))
+	assert.Nil(t, err, "NewFailoverQueue")
+	strict(fq.Alternates[0])
+	err = fq.EnqueueString("diverted", nil)
+	assert.Nil(t, err, "EnqueueString fails over")
+
+	// A user key is still checked
+	err = dq.EnqueueString("data", &Options{Metadata: map[string]string{"other": "x"}})
+	assert.True(t, errors.Is(err, ErrInvalidMetadata), "user key rejected")
+}
@@ -0,0 +1,57 @@
+package dirqueue
+
+import (
+	"strings"
+	"time"
+)
+
+// Query selects jobs for Search. Zero fields match every job.
+type Query struct {
+	State      JobState          // pending or active
+	Meta       map[string]string // metadata values that must match exactly
+	MetaPrefix map[string]string // metadata values must start with these
+
+	// MinPriority and MaxPriority bound job priorities, inclusive.
+	// MaxPriority only applies if HasMaxPriority is set, so that a
+	// query can select priority 0 alone.
+	MinPriority    uint8
+	MaxPriority    uint8
+	HasMaxPriority bool
+
+	// MinAge and MaxAge bound how long ago jobs were enqueued. A zero
+	// MaxAge means no upper bound.
+	MinAge time.Duration
+	MaxAge time.Duration
+}
+
+// Match reports whether info satisfies the query
+func (q *Query) Match(info *JobInfo) bool {
+	if q.State != "" && info.State != q.State {
+		return false
+	}
+	if info.Priority < q.MinPriority ||
+		(q.HasMaxPriority && info.Priority > q.MaxPriority) {
+		return false
+	}
+	age := info.Age()
+	if age < q.MinAge || (q.MaxAge != 0 && age > q.MaxAge) {
+		return false
+	}
+	for k, v := range q.Meta {
+		if val, ok := info.Metadata[k]; !ok || val != v {
+			return false
+		}
+	}
+	for k, prefix := range q.MetaPrefix {
+		if val, ok := info.Metadata[k]; !ok || !strings.HasPrefix(val, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the JobInfo of each job in the queue matching q, in
+// pickup order. Nothing is claimed.
+func (dq *DirQueue) Search(q Query) ([]JobInfo, error) {
+	return dq.ListJobs(q.Match)
+}
@@ -0,0 +1,55 @@
+package dirqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	for _, opts := range []*Options{
+		{Priority: 10, Metadata: map[string]string{"bucket": "logs-eu", "env": "prod"}},
+		{Priority: 50, Metadata: map[string]string{"bucket": "logs-us", "env": "prod"}},
+		{Priority: 90, Metadata: map[string]string{"bucket": "images", "env": "test"},
+			EnqueueTime: time.Now().Add(-2 * time.Hour)},
+	} {
+		err = dq.EnqueueString("data", opts)
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint8
+	}{
+		{"all", Query{}, []uint8{10, 50, 90}},
+		{"meta", Query{Meta: map[string]string{"env": "prod"}}, []uint8{10, 50}},
+		{"meta missing", Query{Meta: map[string]string{"owner": ""}}, nil},
+		{"prefix", Query{MetaPrefix: map[string]string{"bucket": "logs-"}}, []uint8{10, 50}},
+		{"priority", Query{MinPriority: 20, MaxPriority: 60, HasMaxPriority: true}, []uint8{50}},
+		{"max priority", Query{MaxPriority: 10, HasMaxPriority: true}, []uint8{10}},
+		{"zero max priority", Query{HasMaxPriority: true}, nil},
+		{"min priority", Query{MinPriority: 20}, []uint8{50, 90}},
+		{"older", Query{MinAge: time.Hour}, []uint8{90}},
+		{"newer", Query{MaxAge: time.Hour}, []uint8{10, 50}},
+		{"active", Query{State: StateActive}, nil},
+		{"combined", Query{
+			Meta:           map[string]string{"env": "prod"},
+			MetaPrefix:     map[string]string{"bucket": "logs-u"},
+			MaxPriority:    50,
+			HasMaxPriority: true,
+		}, []uint8{50}},
+	}
+	for _, tc := range tests {
+		jobs, err := dq.Search(tc.query)
+		assert.Nil(t, err, tc.name+" Search")
+		var got []uint8
+		for _, info := range jobs {
+			got = append(got, info.Priority)
+		}
+		assert.Equal(t, tc.want, got, tc.name)
+	}
+}
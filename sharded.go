@@ -0,0 +1,115 @@
+package dirqueue
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ShardedQueue distributes enqueues across a set of queues (e.g. on
+// different filesystems), choosing a shard by rendezvous hashing of a
+// metadata value, so that adding or removing a shard only moves the
+// keys that hashed to it. Jobs without the key are spread round-robin.
+// Consumers may pick up from a single shard, or from all of them with
+// PickupQueuedJob and WaitForQueuedJob.
+type ShardedQueue struct {
+	Shards     []*DirQueue
+	Key        string // Options.Metadata key to shard on
+	next       uint32
+	pickupNext uint32
+}
+
+// NewShardedQueue returns a reference to a ShardedQueue struct with a
+// shard for each of rootdirs, sharding on the metadata value for key
+func NewShardedQueue(key string, rootdirs ...string) (*ShardedQueue, error) {
+	if len(rootdirs) == 0 {
+		return nil, errors.New("no shard root directories given")
+	}
+	sq := &ShardedQueue{Key: key}
+	for _, rootdir := range rootdirs {
+		dq, err := New(rootdir)
+		if err != nil {
+			return nil, err
+		}
+		sq.Shards = append(sq.Shards, dq)
+	}
+	return sq, nil
+}
+
+// ShardFor returns the shard a job with options opts is enqueued to
+func (sq *ShardedQueue) ShardFor(opts *Options) *DirQueue {
+	var value string
+	if opts != nil {
+		value = opts.Metadata[sq.Key]
+	}
+	if value == "" {
+		n := atomic.AddUint32(&sq.next, 1)
+		return sq.Shards[int(n-1)%len(sq.Shards)]
+	}
+
+	// Rendezvous hashing: pick the shard with the highest hash of
+	// value and the shard's root directory
+	var best *DirQueue
+	var bestScore uint64
+	for _, dq := range sq.Shards {
+		h := fnv.New64a()
+		io.WriteString(h, value)
+		h.Write([]byte{0})
+		io.WriteString(h, dq.RootDir)
+		score := h.Sum64()
+		if best == nil || score > bestScore {
+			best, bestScore = dq, score
+		}
+	}
+	return best
+}
+
+// EnqueueReader enqueues the data in rdr into the shard selected by
+// opts (with options in opts, if set)
+func (sq *ShardedQueue) EnqueueReader(rdr io.Reader, opts *Options) error {
+	return sq.ShardFor(opts).EnqueueReader(rdr, opts)
+}
+
+// EnqueueFile enqueues the data file in path into the shard selected
+// by opts (with options in opts, if set)
+func (sq *ShardedQueue) EnqueueFile(path string, opts *Options) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return sq.EnqueueReader(fh, opts)
+}
+
+// EnqueueString enqueues the data in string into the shard selected
+// by opts (with options in opts, if set)
+func (sq *ShardedQueue) EnqueueString(data string, opts *Options) error {
+	return sq.EnqueueReader(strings.NewReader(data), opts)
+}
+
+// PickupQueuedJob claims the job at the head of the first shard with
+// pending jobs, returning ErrNoJobs if there are none. Each call starts
+// from the shard after the one the last call started from, so no shard
+// is starved. Jobs are only in pickup order within each shard.
+func (sq *ShardedQueue) PickupQueuedJob() (*QueuedJob, error) {
+	start := int(atomic.AddUint32(&sq.pickupNext, 1) - 1)
+	for i := range sq.Shards {
+		dq := sq.Shards[(start+i)%len(sq.Shards)]
+		job, err := dq.PickupQueuedJob()
+		if err != ErrNoJobs {
+			return job, err
+		}
+	}
+	return nil, ErrNoJobs
+}
+
+// WaitForQueuedJob claims a job from any shard as for PickupQueuedJob,
+// waiting for one to be enqueued if necessary, as for
+// DirQueue.WaitForQueuedJob
+func (sq *ShardedQueue) WaitForQueuedJob(timeout, pollInterval time.Duration) (*QueuedJob, error) {
+	return waitForJob(sq.PickupQueuedJob, timeout, pollInterval)
+}
@@ -0,0 +1,110 @@
+package dirqueue
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedQueue(t *testing.T) {
+	parent := filepath.Join("testqueue", "shards")
+	nukeTree(t, parent)
+	defer nukeTree(t, parent)
+
+	var rootdirs []string
+	for i := 0; i < 3; i++ {
+		rootdirs = append(rootdirs, filepath.Join(parent, fmt.Sprintf("shard%d", i)))
+	}
+	sq, err := NewShardedQueue("customer", rootdirs...)
+	assert.Nil(t, err, "constructor")
+
+	// The same key always maps to the same shard
+	for i := 0; i < 5; i++ {
+		opts := DefaultOptions()
+		opts.Metadata["customer"] = "acme"
+		err = sq.EnqueueString("data", opts)
+		assert.Nil(t, err, "EnqueueString keyed")
+	}
+	opts := DefaultOptions()
+	opts.Metadata["customer"] = "acme"
+	shard := sq.ShardFor(opts)
+	pending, err := shard.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 5, pending, "keyed jobs on one shard")
+
+	// Removing another shard doesn't move the key
+	var others []*DirQueue
+	for _, dq := range sq.Shards {
+		if dq != shard {
+			others = append(others, dq)
+		}
+	}
+	smaller := &ShardedQueue{Shards: []*DirQueue{shard, others[0]}, Key: "customer"}
+	assert.Equal(t, shard, smaller.ShardFor(opts), "key stays on shard")
+
+	// Unkeyed jobs are spread across all shards
+	for i := 0; i < 3; i++ {
+		err = sq.EnqueueString("data", nil)
+		assert.Nil(t, err, "EnqueueString unkeyed")
+	}
+	for _, dq := range others {
+		pending, err := dq.PendingCount()
+		assert.Nil(t, err, "PendingCount")
+		assert.Equal(t, 1, pending, "unkeyed job on other shard")
+	}
+
+	_, err = NewShardedQueue("customer")
+	assert.NotNil(t, err, "no shards")
+}
+
+func TestShardedPickup(t *testing.T) {
+	var rootdirs []string
+	for i := 0; i < 3; i++ {
+		rootdirs = append(rootdirs, t.TempDir())
+	}
+	sq, err := NewShardedQueue("customer", rootdirs...)
+	assert.Nil(t, err, "constructor")
+
+	_, err = sq.PickupQueuedJob()
+	assert.Equal(t, ErrNoJobs, err, "PickupQueuedJob empty")
+	_, err = sq.WaitForQueuedJob(10*time.Millisecond, time.Millisecond)
+	assert.Equal(t, ErrNoJobs, err, "WaitForQueuedJob timeout")
+
+	// Jobs on every shard are picked up, round-robin across shards
+	for _, dq := range sq.Shards {
+		for i := 0; i < 2; i++ {
+			err = dq.EnqueueString(dq.RootDir, nil)
+			assert.Nil(t, err, "EnqueueString")
+		}
+	}
+	var roots []string
+	for {
+		job, err := sq.PickupQueuedJob()
+		if err == ErrNoJobs {
+			break
+		}
+		if !assert.Nil(t, err, "PickupQueuedJob") {
+			return
+		}
+		data, err := job.Data()
+		assert.Nil(t, err, "Data")
+		roots = append(roots, string(data))
+		assert.Nil(t, job.Finish(), "Finish")
+	}
+	if assert.Equal(t, 6, len(roots), "all jobs picked up") {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, roots[i], roots[i+3], "round-robin pickup")
+			assert.NotEqual(t, roots[i], roots[(i+1)%3], "shards alternate")
+		}
+	}
+
+	err = sq.Shards[1].EnqueueString("late", nil)
+	assert.Nil(t, err, "EnqueueString")
+	job, err := sq.WaitForQueuedJob(time.Second, time.Millisecond)
+	if assert.Nil(t, err, "WaitForQueuedJob") {
+		assert.Nil(t, job.Finish(), "Finish")
+	}
+}
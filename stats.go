@@ -0,0 +1,193 @@
+package dirqueue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats summarises the jobs currently in a queue
+type Stats struct {
+	Pending       int
+	Active        int
+	PendingBytes  int64
+	ActiveBytes   int64
+	Priorities    map[uint8]int  // pending job counts, by priority
+	Bands         map[string]int // pending job counts, by priority band name
+	OldestPending time.Time      // enqueue time of the oldest pending job
+	Quarantined   int            // malformed control files in badctrl/
+}
+
+// readControlFile parses the control file in path into a map of
+// control keys and metadata to values. ErrBadControlFile is returned
+// if the file is truncated or malformed.
+func readControlFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		return nil, fmt.Errorf("%w: %q is truncated", ErrBadControlFile, path)
+	}
+	ctrl := make(map[string]string)
+	for _, line := range strings.Split(string(data[:len(data)-1]), "\n") {
+		idx := strings.Index(line, ": ")
+		if idx < 1 {
+			return nil, fmt.Errorf("%w: %q has invalid line %q",
+				ErrBadControlFile, path, line)
+		}
+		ctrl[line[:idx]] = line[idx+2:]
+	}
+	for _, key := range []string{"QDSB", "QSTT"} {
+		_, err = strconv.ParseInt(ctrl[key], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q has invalid %s",
+				ErrBadControlFile, path, key)
+		}
+	}
+	if ctrl["QDFN"] == "" {
+		return nil, fmt.Errorf("%w: %q has no QDFN", ErrBadControlFile, path)
+	}
+	return ctrl, nil
+}
+
+// ctrlEnqueueTime returns the enqueue time recorded in the QSTT and
+// QSTM entries of ctrl, or the zero time if they are missing
+func ctrlEnqueueTime(ctrl map[string]string) time.Time {
+	secs, err := strconv.ParseInt(ctrl["QSTT"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	usecs, _ := strconv.ParseInt(ctrl["QSTM"], 10, 64)
+	return time.Unix(secs, usecs*1000).UTC()
+}
+
+// qfnamePriority returns the priority encoded at the start of the
+// queue filename qfname
+func qfnamePriority(qfname string) (uint8, bool) {
+	idx := strings.Index(qfname, ".")
+	if idx < 1 {
+		return 0, false
+	}
+	pri, err := strconv.ParseUint(qfname[:idx], 10, 8)
+	if err != nil {
+		return 0, false
+	}
+	return uint8(pri), true
+}
+
+// queueEntries returns the names of the regular files in dir,
+// skipping dotfiles
+func queueEntries(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// Stats scans the queue and returns a summary of its pending and
+// active jobs
+func (dq *DirQueue) Stats() (*Stats, error) {
+	stats := &Stats{Priorities: make(map[uint8]int), Bands: make(map[string]int)}
+	err := dq.scanJobs(func(info *JobInfo) error {
+		if info.State == StateActive {
+			stats.Active++
+			stats.ActiveBytes += info.Size
+			return nil
+		}
+		stats.Pending++
+		stats.PendingBytes += info.Size
+		stats.Priorities[info.Priority]++
+		if info.Band != "" {
+			stats.Bands[info.Band]++
+		}
+		ts := info.EnqueueTime
+		if !ts.IsZero() && (stats.OldestPending.IsZero() || ts.Before(stats.OldestPending)) {
+			stats.OldestPending = ts
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Quarantined, err = dq.quarantinedCount()
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// PendingCount returns the number of pending jobs in the queue. Only
+// directory entries are read, not control files, so it is cheap
+// enough for frequent health checks.
+func (dq *DirQueue) PendingCount() (int, error) {
+	active, err := dq.entries(dq.ActiveDir)
+	if err != nil {
+		return 0, err
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, name := range active {
+		isActive[name] = true
+	}
+
+	queued, err := dq.entries(dq.QueueDir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, qfname := range queued {
+		if !isActive[qfname] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ActiveCount returns the number of jobs in the queue currently locked
+// by consumers. Like PendingCount, only directory entries are read.
+func (dq *DirQueue) ActiveCount() (int, error) {
+	active, err := dq.entries(dq.ActiveDir)
+	if err != nil {
+		return 0, err
+	}
+	return len(active), nil
+}
+
+// depthPollInterval is how often WaitUntilDepthBelow rechecks the
+// queue depth
+var depthPollInterval = 500 * time.Millisecond
+
+// WaitUntilDepthBelow blocks until the number of pending jobs in the
+// queue is less than n, or until ctx is done, in which case ctx.Err()
+// is returned. It allows producers to apply backpressure when
+// consumers fall behind.
+func (dq *DirQueue) WaitUntilDepthBelow(ctx context.Context, n int) error {
+	ticker := time.NewTicker(depthPollInterval)
+	defer ticker.Stop()
+	for {
+		pending, err := dq.PendingCount()
+		if err != nil {
+			return err
+		}
+		if pending < n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
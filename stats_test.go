@@ -0,0 +1,95 @@
+package dirqueue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	start := time.Now().Add(-time.Second)
+	for _, pri := range []uint8{50, 50, 20} {
+		opts := DefaultOptions()
+		opts.Priority = pri
+		err = dq.EnqueueString("0123456789", opts)
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	// Mark one job as active by creating a lock file for it
+	cf, err := filepath.Glob(filepath.Join(testq, "queue", "20.*"))
+	assert.Nil(t, err, "control file Glob")
+	if assert.Equal(t, 1, len(cf), "one priority 20 control file found") {
+		err = ioutil.WriteFile(filepath.Join(dq.ActiveDir, filepath.Base(cf[0])), nil, 0644)
+		assert.Nil(t, err, "active lock write")
+	}
+
+	stats, err := dq.Stats()
+	assert.Nil(t, err, "Stats")
+	assert.Equal(t, 2, stats.Pending, "pending count")
+	assert.Equal(t, int64(20), stats.PendingBytes, "pending bytes")
+	assert.Equal(t, 1, stats.Active, "active count")
+	assert.Equal(t, int64(10), stats.ActiveBytes, "active bytes")
+	assert.Equal(t, map[uint8]int{50: 2}, stats.Priorities, "priority counts")
+	assert.True(t, stats.OldestPending.After(start), "oldest pending time")
+
+	pending, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 2, pending, "PendingCount")
+	active, err := dq.ActiveCount()
+	assert.Nil(t, err, "ActiveCount")
+	assert.Equal(t, 1, active, "ActiveCount")
+}
+
+func TestWaitUntilDepthBelow(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	saved := depthPollInterval
+	depthPollInterval = 10 * time.Millisecond
+	defer func() { depthPollInterval = saved }()
+
+	err = dq.WaitUntilDepthBelow(context.Background(), 1)
+	assert.Nil(t, err, "empty queue")
+
+	for i := 0; i < 2; i++ {
+		err = dq.EnqueueString("data", nil)
+		assert.Nil(t, err, "EnqueueString")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = dq.WaitUntilDepthBelow(ctx, 2)
+	assert.Equal(t, context.DeadlineExceeded, err, "depth not below 2")
+
+	// Removing a control file (as a consumer would) unblocks the wait
+	cf, err := filepath.Glob(filepath.Join(testq, "queue", "*"))
+	assert.Nil(t, err, "control file Glob")
+	done := make(chan error)
+	go func() {
+		done <- dq.WaitUntilDepthBelow(context.Background(), 2)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	assert.Nil(t, os.Remove(cf[0]), "control file remove")
+	select {
+	case err = <-done:
+		assert.Nil(t, err, "depth below 2")
+	case <-time.After(time.Second):
+		t.Error("WaitUntilDepthBelow did not return")
+	}
+}
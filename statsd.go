@@ -0,0 +1,67 @@
+package dirqueue
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatsdEmitter sends queue counters and timings to a statsd (or
+// dogstatsd, if Tags are set) server over UDP. Metrics are sent on
+// a best-effort basis, and send errors are ignored.
+type StatsdEmitter struct {
+	Prefix string
+	Tags   map[string]string
+	conn   net.Conn
+}
+
+// NewStatsdEmitter returns a StatsdEmitter that sends metrics to the
+// statsd server at addr (host:port), with names prefixed by prefix
+func NewStatsdEmitter(addr, prefix string) (*StatsdEmitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdEmitter{Prefix: prefix, Tags: map[string]string{}, conn: conn}, nil
+}
+
+// Close closes the emitter's connection
+func (e *StatsdEmitter) Close() error {
+	return e.conn.Close()
+}
+
+func (e *StatsdEmitter) send(name, value, kind string) {
+	if e == nil {
+		return
+	}
+	var b strings.Builder
+	if e.Prefix != "" {
+		b.WriteString(e.Prefix)
+		b.WriteByte('.')
+	}
+	fmt.Fprintf(&b, "%s:%s|%s", name, value, kind)
+	if len(e.Tags) > 0 {
+		tags := make([]string, 0, len(e.Tags))
+		for k, v := range e.Tags {
+			tags = append(tags, k+":"+v)
+		}
+		sort.Strings(tags)
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+	_, _ = e.conn.Write([]byte(b.String()))
+}
+
+// count sends a counter increment of n for name (a no-op on a nil
+// emitter)
+func (e *StatsdEmitter) count(name string, n int64) {
+	e.send(name, fmt.Sprintf("%d", n), "c")
+}
+
+// timing sends a timing of d milliseconds for name (a no-op on a nil
+// emitter)
+func (e *StatsdEmitter) timing(name string, d time.Duration) {
+	e.send(name, fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond)), "ms")
+}
@@ -0,0 +1,48 @@
+package dirqueue
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdEmitter(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err, "ListenPacket") {
+		return
+	}
+	defer pc.Close()
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+	dq.Statsd, err = NewStatsdEmitter(pc.LocalAddr().String(), "dq")
+	assert.Nil(t, err, "NewStatsdEmitter")
+	defer dq.Statsd.Close()
+	dq.Statsd.Tags["env"] = "test"
+
+	err = dq.EnqueueString("data", nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	var packets []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if !assert.Nil(t, err, "ReadFrom") {
+			break
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	if assert.Equal(t, 2, len(packets), "packets received") {
+		assert.Equal(t, "dq.enqueue:1|c|#env:test", packets[0], "enqueue counter")
+		assert.True(t, strings.HasPrefix(packets[1], "dq.enqueue.time:"), "enqueue timing name")
+		assert.True(t, strings.HasSuffix(packets[1], "|ms|#env:test"), "enqueue timing type")
+	}
+}
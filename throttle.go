@@ -0,0 +1,51 @@
+package dirqueue
+
+import (
+	"io"
+	"time"
+)
+
+// throttledWriter limits the rate of writes to w to rate bytes per
+// second, averaged from its first write
+type throttledWriter struct {
+	w       io.Writer
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func newThrottledWriter(w io.Writer, rate int64) *throttledWriter {
+	return &throttledWriter{w: w, rate: rate}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// Write in chunks of at most a tenth of a second's worth, so the
+	// rate is smooth rather than bursty
+	chunk := int(t.rate / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	total := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		n, err := t.w.Write(p[:n])
+		total += n
+		t.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+
+		due := t.start.Add(time.Duration(float64(t.written) / float64(t.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return total, nil
+}
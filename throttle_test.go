@@ -0,0 +1,38 @@
+package dirqueue
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newThrottledWriter(&buf, 100000)
+	start := time.Now()
+	n, err := w.Write(make([]byte, 25000))
+	assert.Nil(t, err, "Write")
+	assert.Equal(t, 25000, n, "Write count")
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 250*time.Millisecond, "throttled")
+	assert.True(t, elapsed < 2*time.Second, "not over-throttled")
+	assert.Equal(t, 25000, buf.Len(), "all written")
+}
+
+func TestEnqueueThrottled(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+
+	payload := strings.Repeat("x", 20000)
+	start := time.Now()
+	err = dq.EnqueueString(payload, &Options{MaxWriteBytesPerSec: 100000})
+	assert.Nil(t, err, "EnqueueString")
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "throttled")
+
+	info, err := dq.Peek("")
+	assert.Nil(t, err, "Peek")
+	assert.Equal(t, int64(len(payload)), info.Size, "size")
+}
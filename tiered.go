@@ -0,0 +1,70 @@
+package dirqueue
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// isLarge reports whether a payload of size bytes belongs in the
+// queue's LargeDataDir
+func (dq *DirQueue) isLarge(size int64) bool {
+	return dq.LargeDataDir != "" && size > dq.LargeThreshold
+}
+
+// moveToLargeTmp moves the staged data file of job into the tmp
+// subdirectory of dq.LargeDataDir, copying it if LargeDataDir is on
+// another filesystem, so that it can be linked into the large-object
+// data tree. job.pathtmpdata is updated to the new path.
+func (dq *DirQueue) moveToLargeTmp(job *Job) error {
+	tmpdir := filepath.Join(dq.LargeDataDir, "tmp")
+	err := dq.fsop("mkdir", tmpdir, func() error {
+		return ensureDirExists(tmpdir)
+	})
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(tmpdir, filepath.Base(job.pathtmpdata))
+
+	err = os.Rename(job.pathtmpdata, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyFile(job.pathtmpdata, dst)
+		if err == nil {
+			_ = os.Remove(job.pathtmpdata)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	job.pathtmpdata = dst
+	return nil
+}
+
+// copyFile copies the file src to a new file dst, removing dst on
+// failure
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	bufp := copyBufPool.Get().(*[]byte)
+	_, err = io.CopyBuffer(out, in, *bufp)
+	copyBufPool.Put(bufp)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package dirqueue
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLargeDataDir(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.LargeDataDir = t.TempDir()
+	dq.LargeThreshold = 100
+
+	small := strings.Repeat("s", 100)
+	large := strings.Repeat("L", 101)
+	err = dq.EnqueueString(small, &Options{Priority: 10})
+	assert.Nil(t, err, "EnqueueString small")
+	err = dq.EnqueueString(large, &Options{Priority: 20})
+	assert.Nil(t, err, "EnqueueString large")
+
+	jobs, err := dq.ListJobs(nil)
+	assert.Nil(t, err, "ListJobs")
+	if !assert.Equal(t, 2, len(jobs), "two jobs") {
+		return
+	}
+	datadir, _ := filepath.Abs(dq.DataDir)
+	assert.True(t, strings.HasPrefix(jobs[0].DataPath, datadir), "small payload in DataDir")
+	assert.True(t, strings.HasPrefix(jobs[1].DataPath, dq.LargeDataDir), "large payload in LargeDataDir")
+
+	for i, payload := range []string{small, large} {
+		rdr, err := dq.OpenPayload(&jobs[i])
+		if !assert.Nil(t, err, "OpenPayload") {
+			continue
+		}
+		data, err := ioutil.ReadAll(rdr)
+		assert.Nil(t, err, "read payload")
+		rdr.Close()
+		assert.Equal(t, payload, string(data), "payload")
+	}
+
+	// Nothing is left in either tmp dir
+	for _, dir := range []string{dq.TmpDir, filepath.Join(dq.LargeDataDir, "tmp")} {
+		names, err := queueEntries(dir)
+		assert.Nil(t, err, "queueEntries")
+		assert.Equal(t, 0, len(names), "tmp empty")
+	}
+
+	// The cross-filesystem fallback copies, without overwriting
+	dst := filepath.Join(t.TempDir(), "copy")
+	err = copyFile(jobs[1].DataPath, dst)
+	assert.Nil(t, err, "copyFile")
+	data, err := ioutil.ReadFile(dst)
+	assert.Nil(t, err, "ReadFile")
+	assert.Equal(t, large, string(data), "copied data")
+	err = copyFile(jobs[0].DataPath, dst)
+	assert.NotNil(t, err, "copyFile to existing file")
+}
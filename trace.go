@@ -0,0 +1,51 @@
+package dirqueue
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Metadata keys holding a job's W3C trace context. These match the
+// HTTP header names, so an OpenTelemetry propagation.MapCarrier over
+// Options.Metadata or JobInfo.Metadata works with them directly.
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+var reTraceparent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// validTraceparent reports whether tp is a valid W3C traceparent value
+func validTraceparent(tp string) bool {
+	m := reTraceparent.FindStringSubmatch(tp)
+	return m != nil && m[1] != "ff" &&
+		m[2] != "00000000000000000000000000000000" && m[3] != "0000000000000000"
+}
+
+// SetTraceContext records the W3C trace context of the enqueuing span
+// (the traceparent and, if non-empty, tracestate header values) in the
+// job metadata, so that consumers can continue the trace
+func (opts *Options) SetTraceContext(traceparent, tracestate string) error {
+	if !validTraceparent(traceparent) {
+		return fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+	opts.Metadata[TraceparentKey] = traceparent
+	if tracestate != "" {
+		opts.Metadata[TracestateKey] = tracestate
+	}
+	return nil
+}
+
+// TraceContext returns the W3C trace context recorded in the job's
+// metadata by Options.SetTraceContext, with ok false if there is none
+// (or it is invalid)
+func (info *JobInfo) TraceContext() (traceparent, tracestate string, ok bool) {
+	traceparent = info.Metadata[TraceparentKey]
+	if !validTraceparent(traceparent) {
+		return "", "", false
+	}
+	return traceparent, info.Metadata[TracestateKey], true
+}
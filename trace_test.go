@@ -0,0 +1,40 @@
+package dirqueue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceContext(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	opts := &Options{}
+	err = opts.SetTraceContext(tp, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7")
+	assert.Nil(t, err, "SetTraceContext")
+	err = dq.EnqueueString("traced", opts)
+	assert.Nil(t, err, "EnqueueString")
+
+	info, err := dq.Peek("")
+	assert.Nil(t, err, "Peek")
+	gotTP, gotTS, ok := info.TraceContext()
+	assert.True(t, ok, "TraceContext ok")
+	assert.Equal(t, tp, gotTP, "traceparent")
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", gotTS, "tracestate")
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		assert.NotNil(t, (&Options{}).SetTraceContext(bad, ""), bad)
+	}
+
+	_, _, ok = (&JobInfo{Metadata: map[string]string{}}).TraceContext()
+	assert.False(t, ok, "no trace context")
+}
@@ -0,0 +1,169 @@
+package dirqueue
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Transform is a reversible payload transformation, like compression
+// or encryption, applied to job payloads on enqueue
+type Transform interface {
+	// Name identifies the transform in job metadata, and must match
+	// reSimpleName
+	Name() string
+	// NewWriter returns a writer that transforms data written to it
+	// and writes the result to w
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader that reverses the transform on the
+	// data read from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipTransform compresses payloads with gzip at Level (0 meaning the
+// default compression level)
+type GzipTransform struct {
+	Level int
+}
+
+// Name returns "gzip"
+func (t GzipTransform) Name() string {
+	return "gzip"
+}
+
+// NewWriter returns a gzip writer writing to w
+func (t GzipTransform) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := t.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader returns a gzip reader reading from r
+func (t GzipTransform) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// builtinTransforms are available for reading payloads even if not in
+// a queue's Transforms
+var builtinTransforms = []Transform{GzipTransform{}}
+
+// transformNames returns the metadata value recording transforms
+func transformNames(transforms []Transform) (string, error) {
+	names := make([]string, len(transforms))
+	for i, t := range transforms {
+		if !reSimpleName.MatchString(t.Name()) {
+			return "", fmt.Errorf("invalid transform name %q", t.Name())
+		}
+		names[i] = t.Name()
+	}
+	return strings.Join(names, ","), nil
+}
+
+// writePayload copies the data in rdr through transforms (with the
+// first transform applied first) to dst, which writes to fh, and
+// returns the number of bytes written to fh
+func writePayload(dst io.Writer, fh *os.File, rdr io.Reader, transforms []Transform) (int64, error) {
+	w := dst
+	var writers []io.WriteCloser
+	for i := len(transforms) - 1; i >= 0; i-- {
+		tw, err := transforms[i].NewWriter(w)
+		if err != nil {
+			return 0, err
+		}
+		writers = append(writers, tw)
+		w = tw
+	}
+
+	bufp := copyBufPool.Get().(*[]byte)
+	size, err := io.CopyBuffer(w, rdr, *bufp)
+	copyBufPool.Put(bufp)
+	if err != nil {
+		return 0, err
+	}
+	if len(writers) == 0 {
+		return size, nil
+	}
+
+	// Close from the outermost writer in, to flush each into the next
+	for i := len(writers) - 1; i >= 0; i-- {
+		err = writers[i].Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	stat, err := fh.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// transform returns the Transform called name, from the queue's
+// Transforms or the built-in ones
+func (dq *DirQueue) transform(name string) (Transform, error) {
+	for _, t := range dq.Transforms {
+		if t.Name() == name {
+			return t, nil
+		}
+	}
+	for _, t := range builtinTransforms {
+		if t.Name() == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown transform %q", name)
+}
+
+// payloadReader is a chain of readers reversing a job's transforms
+type payloadReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes each reader in the chain, and the data file
+func (r *payloadReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		cerr := r.closers[i].Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// OpenPayload returns a reader for the payload of the job info,
+// reversing any transforms recorded in its metadata
+func (dq *DirQueue) OpenPayload(info *JobInfo) (io.ReadCloser, error) {
+	fh, err := os.Open(info.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	r := &payloadReader{Reader: fh, closers: []io.Closer{fh}}
+	if info.Metadata[transformsKey] == "" {
+		return r, nil
+	}
+
+	// The last transform applied is the outermost
+	names := strings.Split(info.Metadata[transformsKey], ",")
+	for i := len(names) - 1; i >= 0; i-- {
+		t, err := dq.transform(names[i])
+		if err == nil {
+			var tr io.ReadCloser
+			tr, err = t.NewReader(r.Reader)
+			if err == nil {
+				r.Reader = tr
+				r.closers = append(r.closers, tr)
+			}
+		}
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
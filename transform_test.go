@@ -0,0 +1,131 @@
+package dirqueue
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// xorTransform xors each byte with key, to test transform ordering
+type xorTransform struct {
+	key byte
+}
+
+func (t xorTransform) Name() string { return "xor" }
+
+type xorWriter struct {
+	w   io.Writer
+	key byte
+}
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = b ^ x.key
+	}
+	return x.w.Write(buf)
+}
+
+func (x xorWriter) Close() error { return nil }
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= x.key
+	}
+	return n, err
+}
+
+func (x xorReader) Close() error { return nil }
+
+func (t xorTransform) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xorWriter{w, t.key}, nil
+}
+
+func (t xorTransform) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return xorReader{r, t.key}, nil
+}
+
+func TestTransforms(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Mirror, err = New(t.TempDir())
+	assert.Nil(t, err, "mirror constructor")
+	dq.Transforms = []Transform{GzipTransform{}, xorTransform{0x5a}}
+	dq.Mirror.Transforms = dq.Transforms
+
+	payload := strings.Repeat("Here lies the data.\n", 100)
+	err = dq.EnqueueString(payload, nil)
+	assert.Nil(t, err, "EnqueueString")
+
+	for _, q := range []*DirQueue{dq, dq.Mirror} {
+		info, err := q.Peek("")
+		if !assert.Nil(t, err, "Peek") {
+			return
+		}
+		assert.Equal(t, "gzip,xor", info.Metadata["transforms"], "transforms recorded")
+		assert.True(t, info.Size < int64(len(payload)), "payload compressed")
+
+		// Stored data is gzipped, then xored
+		raw, err := ioutil.ReadFile(info.DataPath)
+		assert.Nil(t, err, "ReadFile")
+		assert.Equal(t, int64(len(raw)), info.Size, "QDSB is stored size")
+		assert.Equal(t, byte(0x1f^0x5a), raw[0], "xor applied last")
+
+		rdr, err := q.OpenPayload(info)
+		if !assert.Nil(t, err, "OpenPayload") {
+			return
+		}
+		data, err := ioutil.ReadAll(rdr)
+		assert.Nil(t, err, "ReadAll")
+		assert.Nil(t, rdr.Close(), "Close")
+		assert.Equal(t, payload, string(data), "payload restored")
+	}
+
+	// Built-in transforms can be read without being configured
+	plain, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.Mirror = nil
+	dq.Transforms = []Transform{GzipTransform{Level: 9}}
+	dq.Shadow, dq.ShadowPercent = plain, 100
+	err = dq.EnqueueString(payload, nil)
+	assert.Nil(t, err, "EnqueueString")
+	info, err := plain.Peek("")
+	assert.Nil(t, err, "Peek")
+	rdr, err := plain.OpenPayload(info)
+	assert.Nil(t, err, "OpenPayload")
+	data, err := ioutil.ReadAll(rdr)
+	assert.Nil(t, err, "ReadAll")
+	assert.Equal(t, payload, string(data), "gzip payload restored")
+
+	// Transforms can't be claimed by producers
+	err = dq.EnqueueString(payload, &Options{Metadata: map[string]string{"transforms": "gzip"}})
+	assert.NotNil(t, err, "user transforms metadatum rejected")
+
+	// Copies of transformed jobs are stored as they are
+	moved, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	err = plain.MoveJob(info, moved)
+	assert.Nil(t, err, "MoveJob")
+	info, err = moved.Peek("")
+	assert.Nil(t, err, "Peek moved")
+	assert.Equal(t, "gzip", info.Metadata["transforms"], "moved transforms recorded")
+	rdr, err = moved.OpenPayload(info)
+	assert.Nil(t, err, "OpenPayload moved")
+	data, err = ioutil.ReadAll(rdr)
+	assert.Nil(t, err, "ReadAll moved")
+	assert.Equal(t, payload, string(data), "moved payload restored")
+
+	// Unknown transforms can't be read
+	info.Metadata["transforms"] = "xor"
+	_, err = plain.OpenPayload(info)
+	assert.NotNil(t, err, "OpenPayload unknown transform")
+}
@@ -0,0 +1,109 @@
+package dirqueue
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrTxDone is returned when using an EnqueueTx that has already been
+// committed or rolled back
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+// EnqueueTx stages a set of related jobs to be committed to a queue
+// together, so that either all of them are enqueued or none are. An
+// EnqueueTx is for use by a single goroutine.
+//
+// All payload and control data is written when jobs are staged, so
+// Commit only has to link(2) the staged control files into the queue
+// directory, removing any it has already linked if one fails. Consumers
+// scanning the queue during that brief window could see some of the
+// jobs before the rest, so jobs that must be processed as a set should
+// still be checked by the consumer (e.g. via a manifest).
+type EnqueueTx struct {
+	dq    *DirQueue
+	jobs  []Job
+	start time.Time
+	done  bool
+}
+
+// EnqueueTx returns a new transaction for enqueueing jobs into dq
+func (dq *DirQueue) EnqueueTx() *EnqueueTx {
+	return &EnqueueTx{dq: dq, start: time.Now()}
+}
+
+// EnqueueReader stages the data in rdr for enqueueing on Commit (with
+// options in opts, if set)
+func (tx *EnqueueTx) EnqueueReader(rdr io.Reader, opts *Options) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	job, err := tx.dq.stageJob(rdr, opts, tx.jobs)
+	if err != nil {
+		return err
+	}
+	tx.jobs = append(tx.jobs, job)
+	return nil
+}
+
+// EnqueueFile stages the data file in path for enqueueing on Commit
+// (with options in opts, if set)
+func (tx *EnqueueTx) EnqueueFile(path string, opts *Options) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return tx.EnqueueReader(fh, opts)
+}
+
+// EnqueueString stages the data in string for enqueueing on Commit
+// (with options in opts, if set)
+func (tx *EnqueueTx) EnqueueString(data string, opts *Options) error {
+	return tx.EnqueueReader(strings.NewReader(data), opts)
+}
+
+// Commit enqueues all the staged jobs. If any of them can't be
+// committed, those already committed are removed again, all staged
+// files are cleaned up, and the error is returned.
+func (tx *EnqueueTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	for i := range tx.jobs {
+		err := tx.dq.commitJob(&tx.jobs[i])
+		if err != nil {
+			for _, job := range tx.jobs {
+				job.cleanup()
+			}
+			tx.dq.Statsd.count("enqueue.error", int64(len(tx.jobs)))
+			return err
+		}
+	}
+
+	var mirrorErr error
+	for _, job := range tx.jobs {
+		err := tx.dq.enqueued(job, tx.start)
+		if err != nil && mirrorErr == nil {
+			mirrorErr = err
+		}
+	}
+	return mirrorErr
+}
+
+// Rollback discards all the staged jobs. It is a no-op on a
+// transaction that has already been committed or rolled back, so can
+// be deferred.
+func (tx *EnqueueTx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	for _, job := range tx.jobs {
+		job.cleanup()
+	}
+}
@@ -0,0 +1,99 @@
+package dirqueue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countFiles(t *testing.T, pattern string) int {
+	files, err := filepath.Glob(pattern)
+	assert.Nil(t, err, "Glob "+pattern)
+	return len(files)
+}
+
+func TestEnqueueTx(t *testing.T) {
+	testq := "testqueue"
+	testfile := "testdata/test1.txt"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	tx := dq.EnqueueTx()
+	opts := DefaultOptions()
+	opts.Metadata["part"] = "manifest"
+	err = tx.EnqueueString("chunk1\nchunk2\n", opts)
+	assert.Nil(t, err, "stage manifest")
+	err = tx.EnqueueString("chunk1 data", nil)
+	assert.Nil(t, err, "stage chunk1")
+	err = tx.EnqueueFile(testfile, nil)
+	assert.Nil(t, err, "stage chunk2")
+
+	// Nothing is visible until commit
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "queue", "*")), "no control files before commit")
+
+	err = tx.Commit()
+	assert.Nil(t, err, "Commit")
+	assert.Equal(t, 3, countFiles(t, filepath.Join(testq, "queue", "*")), "control files after commit")
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "tmp", "*")), "no tmp files after commit")
+	assert.Equal(t, ErrTxDone, tx.Commit(), "second Commit")
+	assert.Equal(t, ErrTxDone, tx.EnqueueString("late", nil), "stage after Commit")
+
+	// Rollback removes everything staged
+	nukeQueue(t, testq)
+	dq, err = New(testq)
+	assert.Nil(t, err, "constructor")
+	tx = dq.EnqueueTx()
+	err = tx.EnqueueString("doomed", nil)
+	assert.Nil(t, err, "stage")
+	tx.Rollback()
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "queue", "*")), "no control files after rollback")
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "data", "?", "?", "*")), "no data files after rollback")
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "tmp", "*")), "no tmp files after rollback")
+
+	// A failed commit removes the jobs already committed
+	tx = dq.EnqueueTx()
+	for i := 0; i < 2; i++ {
+		err = tx.EnqueueString("data", nil)
+		assert.Nil(t, err, "stage")
+	}
+	assert.Nil(t, os.Remove(tx.jobs[1].pathtmpctrl), "break second job")
+	err = tx.Commit()
+	assert.NotNil(t, err, "failed Commit")
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "queue", "*")), "no control files after failed commit")
+	assert.Equal(t, 0, countFiles(t, filepath.Join(testq, "data", "?", "?", "*")), "no data files after failed commit")
+}
+
+func TestEnqueueTxQuota(t *testing.T) {
+	dq, err := New(t.TempDir())
+	assert.Nil(t, err, "constructor")
+	dq.MaxJobs = 2
+	dq.MaxBytes = 12
+	err = dq.EnqueueString("0123", nil)
+	assert.Nil(t, err, "enqueue")
+
+	// Staged jobs count towards the quotas
+	tx := dq.EnqueueTx()
+	err = tx.EnqueueString("0123", nil)
+	assert.Nil(t, err, "stage first")
+	err = tx.EnqueueString("0123", nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "MaxJobs exceeded")
+	tx.Rollback()
+
+	dq.MaxJobs = 0
+	tx = dq.EnqueueTx()
+	err = tx.EnqueueString("0123", nil)
+	assert.Nil(t, err, "stage first")
+	err = tx.EnqueueString("01234", nil)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "MaxBytes exceeded")
+	err = tx.Commit()
+	assert.Nil(t, err, "Commit")
+	count, err := dq.PendingCount()
+	assert.Nil(t, err, "PendingCount")
+	assert.Equal(t, 2, count, "pending jobs")
+}
@@ -0,0 +1,69 @@
+package dirqueue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Watermarks configures WatchWatermarks. OnHigh is called when the
+// pending depth rises to High or above, and OnLow when it then falls
+// back to Low or below. The depth must stay past a mark for Debounce
+// before the callback fires, so brief spikes are ignored.
+type Watermarks struct {
+	High     int
+	Low      int
+	OnHigh   func(depth int)
+	OnLow    func(depth int)
+	Interval time.Duration // how often to check the depth (default 1s)
+	Debounce time.Duration
+}
+
+// WatchWatermarks monitors the pending depth of the queue, calling the
+// w.OnHigh and w.OnLow callbacks as it crosses the high and low
+// watermarks, until ctx is done (returning ctx.Err()) or checking the
+// depth fails
+func (dq *DirQueue) WatchWatermarks(ctx context.Context, w Watermarks) error {
+	if w.Low >= w.High {
+		return errors.New("low watermark must be below high watermark")
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	high := false
+	var crossed time.Time // when depth first passed the next mark
+	for {
+		depth, err := dq.PendingCount()
+		if err != nil {
+			return err
+		}
+
+		past := (!high && depth >= w.High) || (high && depth <= w.Low)
+		if !past {
+			crossed = time.Time{}
+		} else {
+			if crossed.IsZero() {
+				crossed = time.Now()
+			}
+			if time.Since(crossed) >= w.Debounce {
+				high = !high
+				crossed = time.Time{}
+				if high && w.OnHigh != nil {
+					w.OnHigh(depth)
+				} else if !high && w.OnLow != nil {
+					w.OnLow(depth)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package dirqueue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchWatermarks(t *testing.T) {
+	testq := "testqueue"
+
+	nukeQueue(t, testq)
+
+	dq, err := New(testq)
+	assert.Nil(t, err, "constructor")
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	getEvents := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dq.WatchWatermarks(ctx, Watermarks{
+			High:     3,
+			Low:      1,
+			OnHigh:   func(depth int) { record("high") },
+			OnLow:    func(depth int) { record("low") },
+			Interval: 5 * time.Millisecond,
+			Debounce: 20 * time.Millisecond,
+		})
+	}()
+
+	for i := 0; i < 3; i++ {
+		err = dq.EnqueueString("data", nil)
+		assert.Nil(t, err, "EnqueueString")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"high"}, getEvents(), "high watermark crossed")
+
+	// Dropping to 2 is above the low watermark, so nothing fires
+	cf, err := filepath.Glob(filepath.Join(testq, "queue", "*"))
+	assert.Nil(t, err, "control file Glob")
+	assert.Nil(t, os.Remove(cf[0]), "control file remove")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"high"}, getEvents(), "between watermarks")
+
+	assert.Nil(t, os.Remove(cf[1]), "control file remove")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"high", "low"}, getEvents(), "low watermark crossed")
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done, "WatchWatermarks return")
+
+	err = dq.WatchWatermarks(context.Background(), Watermarks{High: 1, Low: 1})
+	assert.NotNil(t, err, "invalid watermarks")
+}